@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sort"
+)
+
+// The advisor simulates the encodings of the collections with other values of
+// the encoding thresholds. The memory used is estimated with the overhead per
+// element of each encoding in Redis 7 on 64 bits, ignoring the rounding of the
+// allocator: the estimates are meant to compare the candidates.
+const (
+	listpackHeaderSize    = 7 // total bytes, number of elements and end byte
+	listpackEntryOverhead = 2 // encoding and back length of an entry
+
+	// The overhead of a list node: quicklistNode and listpack header
+	quicklistNodeOverhead = 32 + listpackHeaderSize
+)
+
+// fullEncodingOverhead is the overhead per element of the full encodings,
+// compared to the compact encodings.
+var fullEncodingOverhead = map[string]int{
+	// dictEntry, bucket and the header of the field and the value sds
+	hashType: 24 + 8 + 2*4 - 2*listpackEntryOverhead,
+	// dictEntry, bucket and the header of the member sds
+	setType: 24 + 8 + 4 - listpackEntryOverhead,
+	// dictEntry, bucket, skiplist node with 1.33 levels on average and the
+	// header of the member sds, compared to the member and the score
+	sortedSetType: 24 + 8 + 24 + 21 + 4 - 2*listpackEntryOverhead - 8,
+}
+
+// advisorCandidates are the values simulated per kind of threshold, in
+// addition to the current value.
+var advisorCandidates = map[thresholdKind][]int{
+	entriesThreshold:        {64, 128, 256, 512, 1024, 2048},
+	valueThreshold:          {32, 64, 128, 256, 512},
+	integerEntriesThreshold: {128, 512, 1024, 2048, 4096, 8192},
+	nodeSizeThreshold:       {-1, -2, -3, -4, -5},
+}
+
+// CandidateAdvice is the simulation of a value of a setting.
+type CandidateAdvice struct {
+	Value       int
+	CompactKeys int // collections in a compact encoding, fitting in a single node for lists
+	SavedBytes  int // estimated, negative if more memory is used
+}
+
+// SettingAdvice is the simulation of the values of a setting, the other
+// settings keeping their current value.
+type SettingAdvice struct {
+	Setting    string
+	Type       string
+	Current    int
+	Keys       int               // collections of the type
+	Candidates []CandidateAdvice // sorted by value
+}
+
+// Best returns the candidate saving the most memory, the current value if
+// none saves memory.
+func (a SettingAdvice) Best() CandidateAdvice {
+	var res CandidateAdvice
+	for _, c := range a.Candidates {
+		if c.Value == a.Current && res.SavedBytes <= 0 {
+			res = c
+		}
+		if c.SavedBytes > res.SavedBytes {
+			res = c
+		}
+	}
+	return res
+}
+
+// AdvisorStats are the simulations of the settings of the compact encodings.
+type AdvisorStats struct {
+	Settings []SettingAdvice
+}
+
+// advisorCollector simulates the candidate values of each encoding threshold.
+type advisorCollector struct {
+	thresholds []encodingThreshold
+	settings   []SettingAdvice
+}
+
+func newAdvisorCollector(thresholds []encodingThreshold) *advisorCollector {
+	c := &advisorCollector{thresholds: thresholds}
+
+	for _, t := range thresholds {
+		values := []int{t.limit}
+		for _, v := range advisorCandidates[t.kind] {
+			if v != t.limit {
+				values = append(values, v)
+			}
+		}
+		sort.Ints(values)
+		if t.kind == nodeSizeThreshold {
+			// From the smallest nodes to the biggest ones
+			sort.Sort(sort.Reverse(sort.IntSlice(values)))
+		}
+
+		a := SettingAdvice{Setting: t.setting, Type: t.typ, Current: t.limit}
+		for _, v := range values {
+			a.Candidates = append(a.Candidates, CandidateAdvice{Value: v})
+		}
+		c.settings = append(c.settings, a)
+	}
+
+	return c
+}
+
+func (c *advisorCollector) add(k KeyInfo) {
+	for i, t := range c.thresholds {
+		if t.typ != k.Type {
+			continue
+		}
+
+		a := &c.settings[i]
+		a.Keys++
+
+		if t.kind == nodeSizeThreshold {
+			nodes := listNodes(k, t.limit)
+			for j := range a.Candidates {
+				cand := &a.Candidates[j]
+				n := listNodes(k, cand.Value)
+				if n <= 1 {
+					cand.CompactKeys++
+				}
+				cand.SavedBytes += (nodes - n) * quicklistNodeOverhead
+			}
+			continue
+		}
+
+		current := compactEncoding(k, c.thresholds, "", 0) != ""
+		for j := range a.Candidates {
+			cand := &a.Candidates[j]
+			compact := compactEncoding(k, c.thresholds, t.setting, cand.Value) != ""
+			if compact {
+				cand.CompactKeys++
+			}
+
+			switch {
+			case compact && !current:
+				cand.SavedBytes += k.Length * fullEncodingOverhead[k.Type]
+			case !compact && current:
+				cand.SavedBytes -= k.Length * fullEncodingOverhead[k.Type]
+			}
+		}
+	}
+}
+
+func (c *advisorCollector) finish(s *Stats) {
+	s.Advisor = &AdvisorStats{Settings: c.settings}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ajstarks/svgo"
+)
+
+// Chart primitives shared by the panels. They all render in a box whose top
+// is left for the title of the panel, see renderPanelTitle.
+
+const (
+	axisLabelWidth  = 80 // left of the plot area, for the labels of the vertical axis
+	axisLabelHeight = 30 // below the plot area, for the labels of the horizontal axis
+	tickLength      = 5
+
+	barLabelWidth  = 300
+	barValueWidth  = 320
+	maxBarHeight   = 30
+	chartFontSize  = 10
+	panelTitleSize = fontSize
+
+	// panelRows is the maximum number of bars of a bar panel.
+	panelRows = 10
+)
+
+// humanCount formats n with a decimal unit.
+func humanCount(n float64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.3gG", n/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.3gM", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.3gk", n/1e3)
+	default:
+		return fmt.Sprintf("%.3g", n)
+	}
+}
+
+func formatBytes(v float64) string { return humanBytes(int(v)) }
+
+func formatPercent(v float64) string { return fmt.Sprintf("%.0f%%", v) }
+
+// truncate shortens s to n runes, to nothing if n isn't positive.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+// renderPanelBackground renders the background of the panel in b.
+func renderPanelBackground(canvas *svg.SVG, b box) {
+	canvas.Rect(b.x, b.y, b.w, b.h, reportTheme().panelStyle())
+}
+
+// renderPanelTitle renders the title at the top left corner of b.
+func renderPanelTitle(canvas *svg.SVG, b box, title string) {
+	canvas.Text(b.x+insideTextPadding, b.y+insideTextPadding+panelTitleSize, title)
+}
+
+// renderBarPanel renders a panel with its title and the bars of the first
+// panelRows values, see renderBarChart.
+func renderBarPanel(canvas *svg.SVG, b box, title string, bars []bar, format func(float64) string) {
+	renderPanelBackground(canvas, b)
+	renderPanelTitle(canvas, b, title)
+
+	if len(bars) > panelRows {
+		bars = bars[:panelRows]
+	}
+	renderBarChart(canvas, b, bars, format)
+}
+
+// renderStackedBarPanel renders a panel with its title and the first
+// panelRows rows, see renderStackedBars.
+func renderStackedBarPanel(canvas *svg.SVG, b box, title string, rows []stackedBar) {
+	renderPanelBackground(canvas, b)
+	renderPanelTitle(canvas, b, title)
+
+	if len(rows) > panelRows {
+		rows = rows[:panelRows]
+	}
+	renderStackedBars(canvas, b, rows)
+}
+
+// plotArea returns the area of b left for a chart with axes.
+func plotArea(b box) box {
+	return box{
+		x: b.x + axisLabelWidth,
+		y: b.y + titleHeight,
+		w: b.w - axisLabelWidth - insideTextPadding*2,
+		h: b.h - titleHeight - axisLabelHeight - insideTextPadding,
+	}
+}
+
+// axis maps values to positions along an axis of a chart.
+type axis struct {
+	min, max float64
+	log      bool
+	base     float64 // between two ticks of a logarithmic axis
+	format   func(v float64) string
+}
+
+// niceStep returns a round step close to raw: 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+
+	exp := math.Pow(10, math.Floor(math.Log10(raw)))
+	switch f := raw / exp; {
+	case f <= 1:
+		return exp
+	case f <= 2:
+		return 2 * exp
+	case f <= 5:
+		return 5 * exp
+	default:
+		return 10 * exp
+	}
+}
+
+// linearAxis returns an axis from 0 to a round value above max.
+func linearAxis(max float64, format func(float64) string) axis {
+	step := niceStep(max / 5)
+	return axis{
+		max:    math.Max(step, math.Ceil(max/step)*step),
+		format: format,
+	}
+}
+
+// logAxis returns a logarithmic axis from a power of base below min to a power
+// of base above max.
+func logAxis(min, max, base float64, format func(float64) string) axis {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+
+	a := axis{
+		min:    math.Pow(base, math.Floor(math.Log(min)/math.Log(base))),
+		max:    math.Pow(base, math.Ceil(math.Log(max)/math.Log(base))),
+		log:    true,
+		base:   base,
+		format: format,
+	}
+	if a.max <= a.min {
+		a.max = a.min * base
+	}
+
+	return a
+}
+
+// pos returns the position of v on an axis of the given length.
+func (a axis) pos(v float64, length int) int {
+	if a.max <= a.min {
+		return 0
+	}
+
+	var r float64
+	if a.log {
+		if v < a.min {
+			v = a.min
+		}
+		r = (math.Log(v) - math.Log(a.min)) / (math.Log(a.max) - math.Log(a.min))
+	} else {
+		r = (v - a.min) / (a.max - a.min)
+	}
+	r = math.Max(0, math.Min(1, r))
+
+	return int(r*float64(length) + 0.5)
+}
+
+func (a axis) ticks() []float64 {
+	var res []float64
+
+	if a.log {
+		for v := a.min; v <= a.max*(1+1e-9); v *= a.base {
+			res = append(res, v)
+		}
+		return res
+	}
+
+	step := niceStep((a.max - a.min) / 5)
+	for v := math.Ceil(a.min/step) * step; v <= a.max+step*1e-9; v += step {
+		res = append(res, v)
+	}
+
+	return res
+}
+
+// renderYAxis renders the vertical axis on the left of area, with a
+// horizontal grid line for each tick.
+func renderYAxis(canvas *svg.SVG, area box, a axis) {
+	th := reportTheme()
+
+	canvas.Gstyle(fmt.Sprintf("font-size:%dpt", chartFontSize))
+	for _, t := range a.ticks() {
+		y := area.y + area.h - a.pos(t, area.h)
+		canvas.Line(area.x, y, area.x+area.w, y, th.gridStyle())
+		canvas.Line(area.x-tickLength, y, area.x, y, th.axisStyle())
+		canvas.Text(area.x-tickLength*2, y+chartFontSize/2, a.format(t), "text-anchor:end")
+	}
+	canvas.Gend()
+
+	canvas.Line(area.x, area.y, area.x, area.y+area.h, th.axisStyle())
+}
+
+// renderXAxis renders the horizontal axis below area.
+func renderXAxis(canvas *svg.SVG, area box, a axis) {
+	th := reportTheme()
+	y := area.y + area.h
+
+	canvas.Gstyle(fmt.Sprintf("font-size:%dpt", chartFontSize))
+	for _, t := range a.ticks() {
+		x := area.x + a.pos(t, area.w)
+		canvas.Line(x, y, x, y+tickLength, th.axisStyle())
+		canvas.Text(x, y+tickLength+chartFontSize+4, a.format(t), "text-anchor:middle")
+	}
+	canvas.Gend()
+
+	canvas.Line(area.x, y, area.x+area.w, y, th.axisStyle())
+}
+
+// bar is a labelled value of a bar chart.
+type bar struct {
+	label string
+	value float64
+	color string
+}
+
+// renderBarChart renders horizontal bars, from the top to the bottom, with
+// their label on the left and their formatted value on the right.
+func renderBarChart(canvas *svg.SVG, b box, bars []bar, format func(float64) string) {
+	rows := make([]stackedBar, len(bars))
+	for i, br := range bars {
+		rows[i] = stackedBar{
+			label: br.label,
+			parts: []barPart{{br.value, br.color}},
+			info:  format(br.value),
+		}
+	}
+
+	renderStackedBars(canvas, b, rows)
+}
+
+// barPart is a part of a stacked bar.
+type barPart struct {
+	value float64
+	color string
+}
+
+// stackedBar is a horizontal bar made of several parts.
+type stackedBar struct {
+	label     string
+	parts     []barPart
+	info      string // written on the right of the bar
+	highlight string // color of the label, the text color of the theme if empty
+}
+
+func (sb stackedBar) total() float64 {
+	var res float64
+	for _, p := range sb.parts {
+		res += p.value
+	}
+	return res
+}
+
+// renderStackedBars renders horizontal stacked bars, all on the same scale.
+func renderStackedBars(canvas *svg.SVG, b box, rows []stackedBar) {
+	if len(rows) == 0 {
+		return
+	}
+
+	var max float64
+	for _, r := range rows {
+		max = math.Max(max, r.total())
+	}
+	if max <= 0 {
+		max = 1
+	}
+
+	rowHeight := (b.h - titleHeight - insideTextPadding) / len(rows)
+	if rowHeight > maxBarHeight {
+		rowHeight = maxBarHeight
+	}
+
+	labelWidth, valueWidth := barLabelWidth, barValueWidth
+	if labelWidth+valueWidth > b.w/2 {
+		labelWidth, valueWidth = b.w/4, b.w/4
+	}
+	barX := b.x + insideTextPadding + labelWidth
+	barWidth := b.w - insideTextPadding*2 - labelWidth - valueWidth
+
+	canvas.Gstyle(fmt.Sprintf("font-size:%dpt", chartFontSize+2))
+	for i, r := range rows {
+		y := b.y + titleHeight + i*rowHeight
+		textY := y + rowHeight/2 + 5
+
+		style := "fill:#" + reportTheme().text
+		if r.highlight != "" {
+			style = "fill:#" + r.highlight
+		}
+		canvas.Text(barX-insideTextPadding, textY, truncate(r.label, labelWidth/8), style+";text-anchor:end")
+
+		x := barX
+		for _, p := range r.parts {
+			w := int(p.value / max * float64(barWidth))
+			canvas.Rect(x, y+4, w, rowHeight-8, "fill:#"+p.color)
+			x += w
+		}
+
+		canvas.Text(barX+barWidth+insideTextPadding, textY, r.info)
+	}
+	canvas.Gend()
+}
+
+// histogramBin is a bin of a histogram.
+type histogramBin struct {
+	label string
+	value float64
+}
+
+// renderHistogram renders vertical bars, one per bin, with the value axis on
+// the left. The labels of the bins are thinned out to not overlap.
+func renderHistogram(canvas *svg.SVG, b box, bins []histogramBin, y axis, color string) {
+	th := reportTheme()
+
+	area := plotArea(b)
+	renderYAxis(canvas, area, y)
+
+	if len(bins) == 0 {
+		return
+	}
+
+	binWidth := area.w / len(bins)
+	labelEvery := 1 + len(bins)*60/(area.w+1)
+
+	canvas.Gstyle(fmt.Sprintf("font-size:%dpt", chartFontSize))
+	for i, bin := range bins {
+		x := area.x + i*binWidth
+
+		if bin.value > 0 && (!y.log || bin.value >= y.min) {
+			h := y.pos(bin.value, area.h)
+			if h == 0 {
+				h = 1
+			}
+			canvas.Rect(x+1, area.y+area.h-h, binWidth-2, h, "fill:#"+color)
+		}
+
+		if i%labelEvery == 0 {
+			canvas.Line(x, area.y+area.h, x, area.y+area.h+tickLength, th.axisStyle())
+			canvas.Text(x, area.y+area.h+tickLength+chartFontSize+4, bin.label, "text-anchor:start")
+		}
+	}
+	canvas.Gend()
+
+	canvas.Line(area.x, area.y+area.h, area.x+area.w, area.y+area.h, th.axisStyle())
+}
+
+// point is a point of a line chart.
+type point struct {
+	x, y float64
+}
+
+// lineSeries is a line of a line chart.
+type lineSeries struct {
+	name   string
+	color  string
+	points []point
+}
+
+// renderLineChart renders the series with axes; the names of the series are
+// written at the top right corner of b.
+func renderLineChart(canvas *svg.SVG, b box, series []lineSeries, x, y axis) {
+	area := plotArea(b)
+	renderYAxis(canvas, area, y)
+	renderXAxis(canvas, area, x)
+
+	for _, s := range series {
+		xs := make([]int, len(s.points))
+		ys := make([]int, len(s.points))
+		for i, p := range s.points {
+			xs[i] = area.x + x.pos(p.x, area.w)
+			ys[i] = area.y + area.h - y.pos(p.y, area.h)
+		}
+		canvas.Polyline(xs, ys, fmt.Sprintf("fill:none;stroke:#%s;stroke-width:2", s.color))
+	}
+
+	// Legend
+
+	canvas.Gstyle(fmt.Sprintf("font-size:%dpt", chartFontSize))
+	lx := b.x + b.w - insideTextPadding
+	for i := len(series) - 1; i >= 0; i-- {
+		s := series[i]
+		canvas.Text(lx, b.y+insideTextPadding+panelTitleSize, s.name, fmt.Sprintf("fill:#%s;text-anchor:end", s.color))
+		lx -= len(s.name)*8 + insideTextPadding*2
+	}
+	canvas.Gend()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// collector gathers statistics on the keys of the RDB file.
+type collector interface {
+	// add is called for every key once all its elements have been read.
+	add(k KeyInfo)
+	// finish stores the collected results in the stats.
+	finish(s *Stats)
+}
+
+// valueCollector is a collector which also reads the values of the strings.
+type valueCollector interface {
+	collector
+	// addValue is called for the value of every string and every element of
+	// the collections, the values of the hashes, before add. k is the key
+	// being read.
+	addValue(k KeyInfo, v []byte)
+}
+
+// entryCollector is a collector which also reads the fields of the hashes.
+type entryCollector interface {
+	collector
+	// addEntry is called for every field of the hashes and its value, before
+	// add. k is the hash being read.
+	addEntry(k KeyInfo, field, value []byte)
+}
+
+// collectorFactory creates a collector for an analysis started at now.
+type collectorFactory func(now time.Time) collector
+
+// collectors lists the available collectors in the order they run.
+var collectors = []struct {
+	name string
+	new  collectorFactory
+}{
+	{"topkeys", func(now time.Time) collector { return newTopKeys(flTopKeys) }},
+	{"prefixes", func(now time.Time) collector { return newPrefixTree(flPrefixDelimiter, flPrefixDepth, now) }},
+	{"longest", func(now time.Time) collector { return make(longestKeys) }},
+	{"biggest", func(now time.Time) collector { return make(biggestKeys) }},
+	{"sizes", func(now time.Time) collector { return new(sizeHistogram) }},
+	{"nottl", func(now time.Time) collector { return newNoTTLCollector(flPrefixDelimiter, flPrefixDepth) }},
+	{"encodings", func(now time.Time) collector { return newEncodingCollector(encodingThresholds, flEncodingMargin) }},
+	{"advisor", func(now time.Time) collector { return newAdvisorCollector(encodingThresholds) }},
+	{"stringprofile", func(now time.Time) collector { return newStringProfiler(flPrefixDelimiter) }},
+	{"compression", func(now time.Time) collector {
+		return newCompressionCollector(flPrefixDelimiter, flCompressionSample, flCompressionBudget)
+	}},
+	{"duplicates", func(now time.Time) collector { return newDuplicatesCollector(flDuplicatesPreview) }},
+	{"distinct", func(now time.Time) collector { return newDistinctCollector(flPrefixDelimiter) }},
+	{"hashfields", func(now time.Time) collector { return newHashFieldsCollector(flPrefixDelimiter, flPrefixDepth) }},
+}
+
+// optInCollectors are not enabled by default, because they are slower.
+var optInCollectors = map[string]bool{
+	"stringprofile": true,
+	"compression":   true,
+	"duplicates":    true,
+	"distinct":      true,
+	"hashfields":    true,
+}
+
+func collectorNames() []string {
+	var res []string
+	for _, c := range collectors {
+		res = append(res, c.name)
+	}
+	return res
+}
+
+// defaultCollectorNames returns the names of the collectors enabled by default.
+func defaultCollectorNames() []string {
+	var res []string
+	for _, c := range collectors {
+		if !optInCollectors[c.name] {
+			res = append(res, c.name)
+		}
+	}
+	return res
+}
+
+// splitList splits a comma separated list, ignoring the empty elements.
+func splitList(s string) []string {
+	var res []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// checkCollectors returns an error if names contains an unknown collector.
+func checkCollectors(names string) error {
+	for _, name := range splitList(names) {
+		var found bool
+		for _, c := range collectors {
+			found = found || c.name == name
+		}
+		if !found {
+			return fmt.Errorf("unknown collector %q, the collectors are %s", name, strings.Join(collectorNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// newCollectors creates the collectors enabled with -collectors.
+func newCollectors(now time.Time) []collector {
+	enabled := make(map[string]bool)
+	for _, name := range splitList(flCollectors) {
+		enabled[name] = true
+	}
+
+	var res []collector
+	for _, c := range collectors {
+		if enabled[c.name] {
+			res = append(res, c.new(now))
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a subcommand of rdbanalyzer, with its own flags.
+type command struct {
+	name  string
+	args  string
+	short string
+	long  string
+	flags func(fs *flag.FlagSet)
+	run   func(args []string) error
+}
+
+// errUsage is returned by a command when its arguments are invalid.
+var errUsage = errors.New("invalid arguments")
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:  "analyze",
+			args:  "<rdb file>",
+			short: "analyze a RDB file and write the reports",
+			long: `Analyze parses the RDB file and writes the reports selected with the flags.
+Without any of -o, -text, -tui, -prom-textfile or -stats, a text report is printed.`,
+			flags: func(fs *flag.FlagSet) {
+				addAnalysisFlags(fs)
+				addOutputFlags(fs)
+				addTextFlags(fs)
+				addPromFlags(fs)
+			},
+			run: runAnalyze,
+		},
+		{
+			name:  "render",
+			args:  "<stats file>",
+			short: "write the reports of a stats file",
+			long: `Render reads a stats file written by 'rdbanalyzer analyze -stats' and writes the
+reports selected with the flags, without parsing the RDB file again.
+Without any of -o, -text, -tui or -prom-textfile, a text report is printed.`,
+			flags: func(fs *flag.FlagSet) {
+				addOutputFlags(fs)
+				addTextFlags(fs)
+				addPromFlags(fs)
+			},
+			run: runRender,
+		},
+		{
+			name:  "serve",
+			args:  "[rdb or stats file]",
+			short: "serve the reports, a JSON API and an upload form",
+			long: `Serve launches a web server with the reports of the file, which is either a RDB
+file or a stats file. Without a file, RDB files can be uploaded on /analyze.
+With -watch, the RDB file is analyzed again each time it changes.`,
+			flags: func(fs *flag.FlagSet) {
+				addAnalysisFlags(fs)
+				addServerFlags(fs, ":8080")
+				addChartFlags(fs)
+				addPromFlags(fs)
+			},
+			run: runServe,
+		},
+		{
+			name:  "diff",
+			args:  "<old file> <new file>",
+			short: "compare two RDB or stats files",
+			long: `Diff prints the differences between two snapshots, each one being either a RDB
+file or a stats file: counts and sizes per type, per database and per prefix.`,
+			flags: func(fs *flag.FlagSet) {
+				addAnalysisFlags(fs)
+				addTextFlags(fs)
+			},
+			run: runDiff,
+		},
+		{
+			name:  "export",
+			args:  "<rdb or stats file>",
+			short: "export the stats as JSON or Prometheus metrics",
+			long: `Export writes the stats of the file, which is either a RDB file or a stats file,
+on the standard output or in the file given with -o.`,
+			flags: func(fs *flag.FlagSet) {
+				addAnalysisFlags(fs)
+				addPromFlags(fs)
+				fs.StringVar(&flExportFormat, "format", "json", "The export format: json or prom")
+				fs.StringVar(&flExportOutput, "o", "", "The output file, the standard output by default")
+			},
+			run: runExport,
+		},
+		{
+			name:  "check",
+			args:  "<rdb or stats file>",
+			short: "check the stats against policy rules",
+			long: `Check evaluates the rules of the -rules file and the thresholds against the
+stats of the file, which is either a RDB file or a stats file, and exits with a
+non-zero status if the file can't be parsed or if a rule fails. A zero
+threshold is disabled.`,
+			flags: func(fs *flag.FlagSet) {
+				addAnalysisFlags(fs)
+				fs.StringVar(&flRules, "rules", "", "The YAML file of rules to evaluate")
+				fs.StringVar(&flJUnit, "junit", "", "The file where to write the results as a JUnit XML report")
+				fs.IntVar(&flMaxKeys, "max-keys", 0, "The maximum number of keys")
+				fs.IntVar(&flMaxBytes, "max-bytes", 0, "The maximum byte size of all the values")
+				fs.IntVar(&flMaxKeySize, "max-key-size", 0, "The maximum byte size of a single key")
+				fs.Float64Var(&flMaxExpiredPercent, "max-expired-percent", 0, "The maximum percentage of expired keys")
+			},
+			run: runCheck,
+		},
+		{
+			name:  "help",
+			args:  "[command]",
+			short: "show the help of a command",
+			flags: func(fs *flag.FlagSet) {},
+			run:   runHelp,
+		},
+	}
+}
+
+var (
+	flConfig      string
+	flPrintConfig bool
+
+	flExportFormat string
+	flExportOutput string
+
+	flRules             string
+	flJUnit             string
+	flMaxKeys           int
+	flMaxBytes          int
+	flMaxKeySize        int
+	flMaxExpiredPercent float64
+)
+
+func addAnalysisFlags(fs *flag.FlagSet) {
+	fs.IntVar(&flTopKeys, "top-keys", 100, "The number of biggest keys to keep")
+	fs.StringVar(&flPrefixDelimiter, "prefix-delimiter", ":", "The delimiter used to split key names into prefixes")
+	fs.IntVar(&flPrefixDepth, "prefix-depth", 3, "The maximum depth of the prefix tree")
+	fs.StringVar(&flCollectors, "collectors", strings.Join(defaultCollectorNames(), ","), "The comma separated list of enabled collectors, among "+strings.Join(collectorNames(), ", "))
+	fs.StringVar(&flEncodingThresholds, "encoding-thresholds", "", "The comma separated list of setting=value overriding the thresholds of the compact encodings of Redis, like hash-max-listpack-entries=512")
+	fs.Float64Var(&flEncodingMargin, "encoding-margin", defaultEncodingMargin, "The proportion above an encoding threshold within which the collections are reported")
+	fs.Float64Var(&flCompressionSample, "compression-sample", defaultCompressionSample, "The proportion of the values compressed by the compression collector")
+	fs.DurationVar(&flCompressionBudget, "compression-budget", defaultCompressionBudget, "The maximum time spent compressing values by the compression collector, the values after it are not sampled")
+	fs.IntVar(&flDuplicatesPreview, "duplicates-preview", 0, "The number of bytes of the duplicated values shown in the reports, none if 0")
+}
+
+func addOutputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flSVGOutput, "o", "", "The output file of the charts, in the format of its extension: .svg, .png or .pdf")
+	addChartFlags(fs)
+	fs.BoolVar(&flText, "text", false, "Print a text report on the standard output")
+	fs.BoolVar(&flTUI, "tui", false, "Explore the key prefixes in a terminal UI")
+	fs.StringVar(&flPromTextfile, "prom-textfile", "", "The file where to write the Prometheus metrics, for the node_exporter textfile collector")
+	fs.StringVar(&flStatsOutput, "stats", "", "The file where to write the stats, to render them later")
+}
+
+func addChartFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flCharts, "charts", strings.Join(panelNames(), ","), "The comma separated list of charts in the SVG report, in order")
+	fs.IntVar(&flSVGWidth, "width", defaultWidth, "The width of the SVG report")
+	fs.IntVar(&flSVGColumns, "columns", defaultColumns, "The number of columns of the SVG report")
+	fs.StringVar(&flTheme, "theme", defaultTheme, "The theme of the SVG report: "+strings.Join(themeNames(), ", "))
+	fs.Float64Var(&flDPI, "dpi", defaultDPI, "The resolution of the PNG and PDF reports")
+}
+
+func addTextFlags(fs *flag.FlagSet) {
+	fs.IntVar(&flTextTop, "text-top", 10, "The number of keys and prefixes shown in the text report")
+	fs.BoolVar(&flTextBars, "bars", false, "Show bar charts in the text report")
+	fs.BoolVar(&flNoColor, "no-color", false, "Disable colors in the text report, only used on a terminal")
+}
+
+func addPromFlags(fs *flag.FlagSet) {
+	fs.IntVar(&flPromPrefixes, "prom-prefixes", 20, "The number of biggest prefixes exported as Prometheus metrics")
+}
+
+func addServerFlags(fs *flag.FlagSet, listenAddr string) {
+	fs.StringVar(&flListenAddr, "l", listenAddr, "The listen address of the web server")
+	fs.StringVar(&flWatch, "watch", "", "The RDB file to analyze again each time it changes")
+	fs.DurationVar(&flWatchInterval, "watch-interval", 10*time.Second, "The interval between two checks of the watched file")
+	fs.IntVar(&flHistory, "history", 5, "The number of snapshots of the watched file kept in memory")
+	fs.IntVar(&flMaxJobs, "max-jobs", 2, "The maximum number of concurrent analysis of uploaded files")
+	fs.IntVar(&flMaxReports, "max-reports", 10, "The maximum number of reports of uploaded files kept in memory")
+	fs.Int64Var(&flMaxUploadSize, "max-upload-size", 4<<30, "The maximum size in bytes of an uploaded file")
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *command) usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: rdbanalyzer %s [flags] %s\n", c.name, c.args)
+	if c.long != "" {
+		fmt.Fprintf(w, "\n%s\n", c.long)
+	}
+
+	var hasFlags bool
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(w, "\nFlags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	c.flags(fs)
+	if c.name != "help" {
+		fs.StringVar(&flConfig, "config", "", "The YAML configuration file, flags given on the command line override its values")
+		fs.BoolVar(&flPrintConfig, "print-config", false, "Print the effective configuration and exit")
+	}
+	fs.Usage = func() { c.usage(os.Stderr, fs) }
+
+	return fs
+}
+
+// runCommand runs the command with the arguments and returns the exit status.
+func runCommand(c *command, args []string) int {
+	fs := c.flagSet()
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	args, err := c.configure(fs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rdbanalyzer %s: %v\n", c.name, err)
+		return 2
+	}
+
+	if flPrintConfig {
+		if err := printConfig(os.Stdout, effectiveConfig(c.name, fs, args)); err != nil {
+			fmt.Fprintf(os.Stderr, "rdbanalyzer %s: %v\n", c.name, err)
+			return 1
+		}
+		return 0
+	}
+
+	switch err := c.run(args); {
+	case err == errUsage:
+		fs.Usage()
+		return 2
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "rdbanalyzer %s: %v\n", c.name, err)
+		return 1
+	}
+
+	return 0
+}
+
+// configure applies the configuration file given with -config to the flags
+// not given on the command line and validates the flags. It returns the
+// arguments of the command, which default to the inputs of the configuration.
+func (c *command) configure(fs *flag.FlagSet) ([]string, error) {
+	args := fs.Args()
+
+	if flConfig != "" {
+		cfg, err := readConfig(flConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		if err := cfg.apply(c.name, fs, set); err != nil {
+			return nil, err
+		}
+
+		if len(args) == 0 {
+			args = cfg.Inputs
+		}
+	}
+
+	if err := checkCollectors(flCollectors); err != nil {
+		return nil, err
+	}
+	if err := parseEncodingFlags(); err != nil {
+		return nil, err
+	}
+	if flCompressionSample < 0 || flCompressionSample > 1 {
+		return nil, fmt.Errorf("invalid compression sample %g, expected a proportion between 0 and 1", flCompressionSample)
+	}
+	if flDuplicatesPreview < 0 {
+		return nil, fmt.Errorf("invalid duplicates preview %d", flDuplicatesPreview)
+	}
+	if _, err := selectedPanels(); err != nil {
+		return nil, err
+	}
+	if err := checkLayout(flSVGWidth, flSVGColumns); err != nil {
+		return nil, err
+	}
+	if flHistory < 1 {
+		return nil, fmt.Errorf("invalid history %d, at least one snapshot must be kept", flHistory)
+	}
+	if err := checkTheme(flTheme); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+func printUsage() {
+	fmt.Println("Usage: rdbanalyzer <command> [flags] [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	for _, c := range commands {
+		fmt.Printf("  %-8s %s\n", c.name, c.short)
+	}
+	fmt.Println("")
+	fmt.Println("Use 'rdbanalyzer help <command>' for more information about a command.")
+	fmt.Println("")
+	fmt.Println("The previous command line, 'rdbanalyzer (-o <output svg file>|-l <listen address>) <rdb file>',")
+	fmt.Println("still works but is deprecated.")
+}
+
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return nil
+	}
+
+	c := findCommand(args[0])
+	if c == nil {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	c.usage(os.Stdout, c.flagSet())
+
+	return nil
+}
+
+// writeReports writes the reports of s selected with the output flags. The
+// terminal UI is started last since it takes over the terminal.
+func writeReports(s *Stats) error {
+	if flStatsOutput != "" {
+		if err := writeStats(flStatsOutput, s); err != nil {
+			return fmt.Errorf("unable to write stats. err=%v", err)
+		}
+	}
+
+	if flPromTextfile != "" {
+		if err := writePromTextfile(flPromTextfile, s); err != nil {
+			return fmt.Errorf("unable to write metrics. err=%v", err)
+		}
+	}
+
+	if flText {
+		if err := writeTextReport(os.Stdout, s); err != nil {
+			return fmt.Errorf("unable to write text report. err=%v", err)
+		}
+	}
+
+	if flTUI {
+		if err := explore(s); err != nil {
+			return fmt.Errorf("unable to explore stats. err=%v", err)
+		}
+	}
+
+	return nil
+}
+
+// writeCommandReports writes the reports of s for the analyze and render
+// commands, defaulting to the text report.
+func writeCommandReports(s *Stats) error {
+	if flSVGOutput == "" && !flText && !flTUI && flPromTextfile == "" && flStatsOutput == "" {
+		flText = true
+	}
+
+	if flSVGOutput != "" {
+		if err := writeReportFile(flSVGOutput, s); err != nil {
+			return err
+		}
+	}
+
+	return writeReports(s)
+}
+
+func runAnalyze(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var s Stats
+	if err := parse(args[0], &s); err != nil {
+		return err
+	}
+
+	return writeCommandReports(&s)
+}
+
+func runRender(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	s, err := readStats(args[0])
+	if err != nil {
+		return err
+	}
+
+	return writeCommandReports(s)
+}
+
+func runServe(args []string) error {
+	switch {
+	case len(args) > 1:
+		return errUsage
+	case flWatch != "" && len(args) > 0:
+		return errors.New("a file can't be served with -watch")
+	case flWatch != "":
+		return watchAndServe(flWatch)
+	case len(args) == 0:
+		return serve(nil)
+	}
+
+	s, err := loadStats(args[0])
+	if err != nil {
+		return err
+	}
+
+	return serve(s)
+}
+
+func runDiff(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	old, err := loadStats(args[0])
+	if err != nil {
+		return err
+	}
+
+	cur, err := loadStats(args[1])
+	if err != nil {
+		return err
+	}
+
+	return writeTextDiff(os.Stdout, old, cur)
+}
+
+func runExport(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var write func(w io.Writer, s *Stats) error
+	switch strings.ToLower(flExportFormat) {
+	case "json":
+		write = func(w io.Writer, s *Stats) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(s)
+		}
+	case "prom":
+		write = writeMetrics
+	default:
+		return fmt.Errorf("unknown export format %q", flExportFormat)
+	}
+
+	s, err := loadStats(args[0])
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if flExportOutput != "" {
+		f, err := os.Create(flExportOutput)
+		if err != nil {
+			return fmt.Errorf("unable to create file '%s'. err=%v", flExportOutput, err)
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	return write(w, s)
+}
+
+func runCheck(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	rules := thresholdRules()
+	if flRules != "" {
+		r, err := readRules(flRules)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r...)
+	}
+
+	start := time.Now()
+
+	s, err := loadStats(args[0])
+	if err != nil {
+		if flJUnit != "" {
+			results := []ruleResult{{rule: Rule{Name: "load"}, err: err}}
+			if err := writeJUnitFile(flJUnit, args[0], results, time.Since(start)); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	results := evaluateRules(s, rules)
+	writeRuleResults(os.Stdout, results)
+
+	if flJUnit != "" {
+		if err := writeJUnitFile(flJUnit, args[0], results, time.Since(start)); err != nil {
+			return err
+		}
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rules failed", failed, len(results))
+	}
+
+	fmt.Printf("ok: %d keys in %d databases, %d rules passed\n", s.Keys.Count, s.Database.Count, len(results))
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compression collector compresses a random sample of the values with
+// several codecs, as a client would before writing them, and extrapolates
+// the ratios to all the values of each type and top level prefix.
+const (
+	defaultCompressionSample = 0.01
+	defaultCompressionBudget = 10 * time.Second
+
+	// maxCompressedValue is the maximum number of bytes of a value
+	// compressed, the ratio of its beginning is used for the whole value.
+	maxCompressedValue = 1 << 20
+)
+
+// codec compresses a value and returns the compressed size.
+type codec struct {
+	name     string
+	compress func(v []byte) int
+}
+
+func newCodecs() []codec {
+	var (
+		gzipBuf            bytes.Buffer
+		zstdBuf, snappyBuf []byte
+	)
+	gz := gzip.NewWriter(&gzipBuf)
+
+	res := []codec{{"gzip", func(v []byte) int {
+		gzipBuf.Reset()
+		gz.Reset(&gzipBuf)
+		gz.Write(v)
+		gz.Close()
+		return gzipBuf.Len()
+	}}}
+
+	// Without a checksum, like most clients
+	if zs, err := zstd.NewWriter(nil, zstd.WithEncoderCRC(false), zstd.WithEncoderConcurrency(1)); err != nil {
+		log.Printf("unable to create the zstd encoder, the values are not compressed with zstd. err=%v", err)
+	} else {
+		res = append(res, codec{"zstd", func(v []byte) int {
+			zstdBuf = zs.EncodeAll(v, zstdBuf[:0])
+			return len(zstdBuf)
+		}})
+	}
+
+	return append(res,
+		codec{"snappy", func(v []byte) int {
+			snappyBuf = snappy.Encode(snappyBuf[:cap(snappyBuf)], v)
+			return len(snappyBuf)
+		}},
+		codec{"lz4", lz4CompressedSize},
+	)
+}
+
+// lz4CompressedSize returns the size of v compressed as a LZ4 block, with
+// the greedy matching of the fast mode of the reference implementation.
+func lz4CompressedSize(v []byte) int {
+	const (
+		minMatch     = 4
+		lastLiterals = 5  // the block ends with literals
+		mfLimit      = 12 // no match starts in the last bytes
+		hashLog      = 12 // 16 KiB table, the default
+		maxOffset    = 65535
+	)
+
+	// The bytes to encode a length beyond the 4 bits of the token
+	extra := func(n int) int {
+		if n < 15 {
+			return 0
+		}
+		return (n-15)/255 + 1
+	}
+
+	var (
+		table  [1 << hashLog]int32 // position + 1 of the last sequence with the hash
+		size   int
+		anchor int
+	)
+	for i := 0; i+mfLimit < len(v); {
+		seq := binary.LittleEndian.Uint32(v[i:])
+		h := (seq * 2654435761) >> (32 - hashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(v[ref:]) != seq {
+			i++
+			continue
+		}
+
+		ml := minMatch
+		for i+ml < len(v)-lastLiterals && v[ref+ml] == v[i+ml] {
+			ml++
+		}
+
+		lit := i - anchor
+		size += 1 + extra(lit) + lit + 2 + extra(ml-minMatch)
+		i += ml
+		anchor = i
+	}
+
+	lit := len(v) - anchor
+	return size + 1 + extra(lit) + lit
+}
+
+// CodecEstimate is the estimated result of a codec on a group of values.
+type CodecEstimate struct {
+	Codec           string
+	CompressedBytes int     // of the sampled values
+	Ratio           float64 // compressed size / size of the sampled values
+	SavedBytes      int     // estimated for all the values, negative if they grow
+}
+
+// CompressionGroup is the estimated compression of the values of a type or
+// of a top level prefix.
+type CompressionGroup struct {
+	Name          string
+	Values        int
+	Bytes         int
+	SampledValues int
+	SampledBytes  int
+	Codecs        []CodecEstimate
+}
+
+// CompressionStats are the estimated compression ratios of the values.
+type CompressionStats struct {
+	Sample          float64 // proportion of the values compressed
+	Budget          time.Duration
+	BudgetExhausted bool // the values after it were not sampled
+	Elapsed         time.Duration
+	Types           []CompressionGroup
+	Prefixes        []CompressionGroup // sorted by bytes, biggest first
+}
+
+// Total returns the estimates of all the values, the sum of the estimates
+// per type.
+func (c *CompressionStats) Total() CompressionGroup {
+	res := CompressionGroup{Name: "total"}
+	for _, t := range c.Types {
+		res.Values += t.Values
+		res.Bytes += t.Bytes
+		res.SampledValues += t.SampledValues
+		res.SampledBytes += t.SampledBytes
+		for i, e := range t.Codecs {
+			if i >= len(res.Codecs) {
+				res.Codecs = append(res.Codecs, CodecEstimate{Codec: e.Codec})
+			}
+			res.Codecs[i].CompressedBytes += e.CompressedBytes
+			res.Codecs[i].SavedBytes += e.SavedBytes
+		}
+	}
+	for i := range res.Codecs {
+		if res.SampledBytes > 0 {
+			res.Codecs[i].Ratio = float64(res.Codecs[i].CompressedBytes) / float64(res.SampledBytes)
+		}
+	}
+	return res
+}
+
+// compressionGroup accumulates the sizes of a group of values.
+type compressionGroup struct {
+	values, bytes               int
+	sampledValues, sampledBytes int
+	compressed                  []int // per codec
+}
+
+func (g *compressionGroup) result(name string, codecs []codec) CompressionGroup {
+	res := CompressionGroup{
+		Name:          name,
+		Values:        g.values,
+		Bytes:         g.bytes,
+		SampledValues: g.sampledValues,
+		SampledBytes:  g.sampledBytes,
+	}
+	for i, c := range codecs {
+		e := CodecEstimate{Codec: c.name}
+		if g.sampledBytes > 0 {
+			e.CompressedBytes = g.compressed[i]
+			e.Ratio = float64(e.CompressedBytes) / float64(g.sampledBytes)
+			e.SavedBytes = int(float64(g.bytes) * (1 - e.Ratio))
+		}
+		res.Codecs = append(res.Codecs, e)
+	}
+	return res
+}
+
+// compressionCollector compresses a random sample of the values until the
+// time spent compressing reaches the budget.
+type compressionCollector struct {
+	delimiter string
+	sample    float64
+	budget    time.Duration
+	rnd       *rand.Rand
+	codecs    []codec
+
+	elapsed  time.Duration
+	types    map[string]*compressionGroup
+	prefixes map[string]*compressionGroup
+}
+
+func newCompressionCollector(delimiter string, sample float64, budget time.Duration) *compressionCollector {
+	return &compressionCollector{
+		delimiter: delimiter,
+		sample:    sample,
+		budget:    budget,
+		// Always the same sample of the same file
+		rnd:      rand.New(rand.NewSource(1)),
+		codecs:   newCodecs(),
+		types:    make(map[string]*compressionGroup),
+		prefixes: make(map[string]*compressionGroup),
+	}
+}
+
+func (c *compressionCollector) group(groups map[string]*compressionGroup, name string) *compressionGroup {
+	g, ok := groups[name]
+	if !ok {
+		g = &compressionGroup{compressed: make([]int, len(c.codecs))}
+		groups[name] = g
+	}
+	return g
+}
+
+func (c *compressionCollector) addValue(k KeyInfo, v []byte) {
+	prefix := boundedKey(c.prefixes, topLevelPrefix(k.Name, c.delimiter), maxProfiledPrefixes)
+	groups := []*compressionGroup{c.group(c.types, k.Type), c.group(c.prefixes, prefix)}
+
+	for _, g := range groups {
+		g.values++
+		g.bytes += len(v)
+	}
+
+	if c.elapsed >= c.budget || c.rnd.Float64() >= c.sample {
+		return
+	}
+
+	if len(v) > maxCompressedValue {
+		v = v[:maxCompressedValue]
+	}
+
+	start := time.Now()
+	for i, codec := range c.codecs {
+		n := codec.compress(v)
+		for _, g := range groups {
+			g.compressed[i] += n
+		}
+	}
+	c.elapsed += time.Since(start)
+
+	for _, g := range groups {
+		g.sampledValues++
+		g.sampledBytes += len(v)
+	}
+}
+
+func (c *compressionCollector) add(k KeyInfo) {}
+
+func (c *compressionCollector) finish(s *Stats) {
+	res := CompressionStats{
+		Sample:          c.sample,
+		Budget:          c.budget,
+		BudgetExhausted: c.elapsed >= c.budget,
+		Elapsed:         c.elapsed,
+	}
+
+	for _, t := range keyTypes {
+		if g, ok := c.types[t]; ok {
+			res.Types = append(res.Types, g.result(t, c.codecs))
+		}
+	}
+
+	for name, g := range c.prefixes {
+		res.Prefixes = append(res.Prefixes, g.result(name, c.codecs))
+	}
+	sort.Slice(res.Prefixes, func(i, j int) bool {
+		a, b := res.Prefixes[i], res.Prefixes[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Name < b.Name
+	})
+	if len(res.Prefixes) > maxProfiledPrefixesReported {
+		res.Prefixes = res.Prefixes[:maxProfiledPrefixesReported]
+	}
+
+	s.Compression = &res
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The sizes are the ones of the blocks of the reference implementation with
+// lz4 -1, the values it can't compress are only literals.
+func TestLZ4CompressedSize(t *testing.T) {
+	var js bytes.Buffer
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&js, `{"id":%d,"name":"user%d","active":true},`, i, i)
+	}
+
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name string
+		v    []byte
+		size int
+	}{
+		{"empty", nil, 1},
+		{"one byte", []byte("a"), 2},
+		{"too short to match", []byte("abcdefghijkl"), 13},
+		{"100 zeros", make([]byte, 100), 11},
+		{"1000 zeros", make([]byte, 1000), 14},
+		{"repeated", bytes.Repeat([]byte("abc"), 100), 14},
+		{"text", bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 20), 59},
+		{"json", js.Bytes(), 486},
+		{"random", random, 1005}, // only literals
+	}
+
+	for _, tt := range tests {
+		if got := lz4CompressedSize(tt.v); got != tt.size {
+			t.Errorf("%s: got %d bytes, expected %d", tt.name, got, tt.size)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the content of a configuration file.
+//
+// Each field sets the value of the flag of the same name, unless the flag is
+// given on the command line. Fields of flags a command doesn't have are ignored,
+// so the same file can be used by all the commands.
+type config struct {
+	Inputs []string `yaml:"inputs,omitempty"`
+
+	Analysis struct {
+		TopKeys         *int     `yaml:"top-keys,omitempty"`
+		PrefixDelimiter *string  `yaml:"prefix-delimiter,omitempty"`
+		PrefixDepth     *int     `yaml:"prefix-depth,omitempty"`
+		Collectors      []string `yaml:"collectors,omitempty"`
+
+		EncodingThresholds []string `yaml:"encoding-thresholds,omitempty"`
+		EncodingMargin     *float64 `yaml:"encoding-margin,omitempty"`
+
+		CompressionSample *float64       `yaml:"compression-sample,omitempty"`
+		CompressionBudget *time.Duration `yaml:"compression-budget,omitempty"`
+
+		DuplicatesPreview *int `yaml:"duplicates-preview,omitempty"`
+	} `yaml:"analysis,omitempty"`
+
+	Output struct {
+		SVG          *string  `yaml:"svg,omitempty"`
+		Charts       []string `yaml:"charts,omitempty"`
+		Width        *int     `yaml:"width,omitempty"`
+		Columns      *int     `yaml:"columns,omitempty"`
+		DPI          *float64 `yaml:"dpi,omitempty"`
+		Theme        *string  `yaml:"theme,omitempty"`
+		Text         *bool    `yaml:"text,omitempty"`
+		TUI          *bool    `yaml:"tui,omitempty"`
+		PromTextfile *string  `yaml:"prom-textfile,omitempty"`
+		Stats        *string  `yaml:"stats,omitempty"`
+	} `yaml:"output,omitempty"`
+
+	Text struct {
+		Top     *int  `yaml:"top,omitempty"`
+		Bars    *bool `yaml:"bars,omitempty"`
+		NoColor *bool `yaml:"no-color,omitempty"`
+	} `yaml:"text,omitempty"`
+
+	Prometheus struct {
+		Prefixes *int `yaml:"prefixes,omitempty"`
+	} `yaml:"prometheus,omitempty"`
+
+	Server struct {
+		Listen        *string        `yaml:"listen,omitempty"`
+		Watch         *string        `yaml:"watch,omitempty"`
+		WatchInterval *time.Duration `yaml:"watch-interval,omitempty"`
+		History       *int           `yaml:"history,omitempty"`
+		MaxJobs       *int           `yaml:"max-jobs,omitempty"`
+		MaxReports    *int           `yaml:"max-reports,omitempty"`
+		MaxUploadSize *int64         `yaml:"max-upload-size,omitempty"`
+	} `yaml:"server,omitempty"`
+
+	Export struct {
+		Format *string `yaml:"format,omitempty"`
+		Output *string `yaml:"output,omitempty"`
+	} `yaml:"export,omitempty"`
+
+	Check struct {
+		Rules *string `yaml:"rules,omitempty"`
+		JUnit *string `yaml:"junit,omitempty"`
+	} `yaml:"check,omitempty"`
+
+	Thresholds struct {
+		MaxKeys           *int     `yaml:"max-keys,omitempty"`
+		MaxBytes          *int     `yaml:"max-bytes,omitempty"`
+		MaxKeySize        *int     `yaml:"max-key-size,omitempty"`
+		MaxExpiredPercent *float64 `yaml:"max-expired-percent,omitempty"`
+	} `yaml:"thresholds,omitempty"`
+}
+
+// configField binds a field of the configuration to a flag.
+type configField struct {
+	flag  string
+	value interface{} // a pointer to the field
+}
+
+// fields returns the fields of the configuration of the command cmd.
+func (c *config) fields(cmd string) []configField {
+	// -o is the output file of the export command and the SVG file of the others
+	output := configField{"o", &c.Output.SVG}
+	if cmd == "export" {
+		output = configField{"o", &c.Export.Output}
+	}
+
+	return []configField{
+		{"top-keys", &c.Analysis.TopKeys},
+		{"prefix-delimiter", &c.Analysis.PrefixDelimiter},
+		{"prefix-depth", &c.Analysis.PrefixDepth},
+		{"collectors", &c.Analysis.Collectors},
+		{"encoding-thresholds", &c.Analysis.EncodingThresholds},
+		{"encoding-margin", &c.Analysis.EncodingMargin},
+		{"compression-sample", &c.Analysis.CompressionSample},
+		{"compression-budget", &c.Analysis.CompressionBudget},
+		{"duplicates-preview", &c.Analysis.DuplicatesPreview},
+
+		output,
+		{"charts", &c.Output.Charts},
+		{"width", &c.Output.Width},
+		{"columns", &c.Output.Columns},
+		{"dpi", &c.Output.DPI},
+		{"theme", &c.Output.Theme},
+		{"text", &c.Output.Text},
+		{"tui", &c.Output.TUI},
+		{"prom-textfile", &c.Output.PromTextfile},
+		{"stats", &c.Output.Stats},
+
+		{"text-top", &c.Text.Top},
+		{"bars", &c.Text.Bars},
+		{"no-color", &c.Text.NoColor},
+
+		{"prom-prefixes", &c.Prometheus.Prefixes},
+
+		{"l", &c.Server.Listen},
+		{"watch", &c.Server.Watch},
+		{"watch-interval", &c.Server.WatchInterval},
+		{"history", &c.Server.History},
+		{"max-jobs", &c.Server.MaxJobs},
+		{"max-reports", &c.Server.MaxReports},
+		{"max-upload-size", &c.Server.MaxUploadSize},
+
+		{"format", &c.Export.Format},
+
+		{"rules", &c.Check.Rules},
+		{"junit", &c.Check.JUnit},
+
+		{"max-keys", &c.Thresholds.MaxKeys},
+		{"max-bytes", &c.Thresholds.MaxBytes},
+		{"max-key-size", &c.Thresholds.MaxKeySize},
+		{"max-expired-percent", &c.Thresholds.MaxExpiredPercent},
+	}
+}
+
+func readConfig(filename string) (*config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file '%s'. err=%v", filename, err)
+	}
+
+	var c config
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to parse config file '%s'. err=%v", filename, err)
+	}
+
+	return &c, nil
+}
+
+// apply sets the flags of fs from the configuration, except the ones in set.
+func (c *config) apply(cmd string, fs *flag.FlagSet, set map[string]bool) error {
+	for _, f := range c.fields(cmd) {
+		if set[f.flag] || fs.Lookup(f.flag) == nil {
+			continue
+		}
+
+		var v string
+		switch p := f.value.(type) {
+		case **int:
+			if *p == nil {
+				continue
+			}
+			v = strconv.Itoa(**p)
+		case **int64:
+			if *p == nil {
+				continue
+			}
+			v = strconv.FormatInt(**p, 10)
+		case **float64:
+			if *p == nil {
+				continue
+			}
+			v = strconv.FormatFloat(**p, 'g', -1, 64)
+		case **bool:
+			if *p == nil {
+				continue
+			}
+			v = strconv.FormatBool(**p)
+		case **string:
+			if *p == nil {
+				continue
+			}
+			v = **p
+		case **time.Duration:
+			if *p == nil {
+				continue
+			}
+			v = (**p).String()
+		case *[]string:
+			if *p == nil {
+				continue
+			}
+			v = strings.Join(*p, ",")
+		}
+
+		if err := fs.Set(f.flag, v); err != nil {
+			return fmt.Errorf("invalid value for -%s in config file. err=%v", f.flag, err)
+		}
+	}
+
+	return nil
+}
+
+// effectiveConfig returns the configuration matching the flags of fs.
+func effectiveConfig(cmd string, fs *flag.FlagSet, inputs []string) *config {
+	c := &config{Inputs: inputs}
+
+	for _, f := range c.fields(cmd) {
+		fl := fs.Lookup(f.flag)
+		if fl == nil {
+			continue
+		}
+
+		switch v := fl.Value.(flag.Getter).Get().(type) {
+		case int:
+			*f.value.(**int) = &v
+		case int64:
+			*f.value.(**int64) = &v
+		case float64:
+			*f.value.(**float64) = &v
+		case bool:
+			*f.value.(**bool) = &v
+		case time.Duration:
+			*f.value.(**time.Duration) = &v
+		case string:
+			if p, ok := f.value.(*[]string); ok {
+				*p = splitList(v)
+			} else {
+				*f.value.(**string) = &v
+			}
+		}
+	}
+
+	return c
+}
+
+func printConfig(w io.Writer, c *config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("unable to print config. err=%v", err)
+	}
+
+	return enc.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// signed formats a delta with an explicit sign.
+func signed(n int) string {
+	if n > 0 {
+		return "+" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// signedBytes formats a byte delta with an explicit sign.
+func signedBytes(n int) string {
+	switch {
+	case n > 0:
+		return "+" + humanBytes(n)
+	case n < 0:
+		return "-" + humanBytes(-n)
+	default:
+		return "0 B"
+	}
+}
+
+// growth returns the relative growth from old to cur as a string.
+func growth(old, cur int) string {
+	if old == 0 {
+		if cur == 0 {
+			return "0.00"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.2f", float64(cur-old)/float64(old)*100)
+}
+
+// writeTextDiff writes the differences between two snapshots as text tables.
+func writeTextDiff(w io.Writer, old, cur *Stats) error {
+	r := newTextReport(w)
+
+	r.title("Global")
+	tw := r.table()
+	fmt.Fprintln(tw, "\tOLD\tNEW\tDELTA\t%\t")
+	fmt.Fprintf(tw, "Databases\t%d\t%d\t%s\t%s\t\n", old.Database.Count, cur.Database.Count, signed(cur.Database.Count-old.Database.Count), growth(old.Database.Count, cur.Database.Count))
+	fmt.Fprintf(tw, "Keys\t%d\t%d\t%s\t%s\t\n", old.Keys.Count, cur.Keys.Count, signed(cur.Keys.Count-old.Keys.Count), growth(old.Keys.Count, cur.Keys.Count))
+	fmt.Fprintf(tw, "Expiring\t%d\t%d\t%s\t%s\t\n", old.Keys.Expiring, cur.Keys.Expiring, signed(cur.Keys.Expiring-old.Keys.Expiring), growth(old.Keys.Expiring, cur.Keys.Expiring))
+	fmt.Fprintf(tw, "Expired\t%d\t%d\t%s\t%s\t\n", old.Keys.Expired, cur.Keys.Expired, signed(cur.Keys.Expired-old.Keys.Expired), growth(old.Keys.Expired, cur.Keys.Expired))
+	oldTotal, curTotal := old.TotalByteSize(), cur.TotalByteSize()
+	fmt.Fprintf(tw, "Total size\t%s\t%s\t%s\t%s\t\n", humanBytes(oldTotal), humanBytes(curTotal), signedBytes(curTotal-oldTotal), growth(oldTotal, curTotal))
+	tw.Flush()
+
+	r.title("Types")
+	tw = r.table()
+	fmt.Fprintln(tw, "TYPE\tKEYS\tDELTA\tSIZE\tDELTA\t%\t")
+	types := []struct {
+		name               string
+		oldCount, curCount int
+		oldBytes, curBytes int
+	}{
+		{"strings", old.Strings.Count, cur.Strings.Count, old.Strings.TotalByteSize, cur.Strings.TotalByteSize},
+		{"lists", old.Lists.Count, cur.Lists.Count, old.Lists.TotalByteSize, cur.Lists.TotalByteSize},
+		{"sets", old.Sets.Count, cur.Sets.Count, old.Sets.TotalByteSize, cur.Sets.TotalByteSize},
+		{"hashes", old.Hashes.Count, cur.Hashes.Count, old.Hashes.TotalByteSize, cur.Hashes.TotalByteSize},
+		{"zsets", old.SortedSets.Count, cur.SortedSets.Count, old.SortedSets.TotalByteSize, cur.SortedSets.TotalByteSize},
+		{"streams", old.Streams.Count, cur.Streams.Count, old.Streams.TotalByteSize, cur.Streams.TotalByteSize},
+		{"modules", old.Modules.Count, cur.Modules.Count, old.Modules.TotalByteSize, cur.Modules.TotalByteSize},
+	}
+	for _, t := range types {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n",
+			t.name,
+			t.curCount, signed(t.curCount-t.oldCount),
+			humanBytes(t.curBytes), signedBytes(t.curBytes-t.oldBytes),
+			growth(t.oldBytes, t.curBytes))
+	}
+	tw.Flush()
+
+	r.title("Databases")
+	tw = r.table()
+	fmt.Fprintln(tw, "DB\tKEYS\tDELTA\tSIZE\tDELTA\t%\t")
+	dbs := make(map[int][2]DBStats)
+	for _, db := range old.Database.Databases {
+		d := dbs[db.DB]
+		d[0] = db
+		dbs[db.DB] = d
+	}
+	for _, db := range cur.Database.Databases {
+		d := dbs[db.DB]
+		d[1] = db
+		dbs[db.DB] = d
+	}
+	var ids []int
+	for id := range dbs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		d := dbs[id]
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t\n",
+			id,
+			d[1].Keys, signed(d[1].Keys-d[0].Keys),
+			humanBytes(d[1].Bytes), signedBytes(d[1].Bytes-d[0].Bytes),
+			growth(d[0].Bytes, d[1].Bytes))
+	}
+	tw.Flush()
+
+	if old.Prefixes != nil && cur.Prefixes != nil {
+		type prefixDelta struct {
+			name     string
+			old, cur *PrefixNode
+		}
+
+		var deltas []prefixDelta
+		for name, c := range cur.Prefixes.Children {
+			deltas = append(deltas, prefixDelta{name, old.Prefixes.Children[name], c})
+		}
+		for name, c := range old.Prefixes.Children {
+			if _, ok := cur.Prefixes.Children[name]; !ok {
+				deltas = append(deltas, prefixDelta{name, c, nil})
+			}
+		}
+
+		bytes := func(n *PrefixNode) int {
+			if n == nil {
+				return 0
+			}
+			return n.Bytes
+		}
+		keys := func(n *PrefixNode) int {
+			if n == nil {
+				return 0
+			}
+			return n.Keys
+		}
+		abs := func(n int) int {
+			if n < 0 {
+				return -n
+			}
+			return n
+		}
+
+		// The prefixes which changed the most come first
+		sort.Slice(deltas, func(i, j int) bool {
+			di := abs(bytes(deltas[i].cur) - bytes(deltas[i].old))
+			dj := abs(bytes(deltas[j].cur) - bytes(deltas[j].old))
+			if di != dj {
+				return di > dj
+			}
+			return deltas[i].name < deltas[j].name
+		})
+
+		r.title(fmt.Sprintf("Top %d prefix changes", r.top))
+		tw = r.table()
+		fmt.Fprintln(tw, "PREFIX\tKEYS\tDELTA\tSIZE\tDELTA\t%\t")
+		for i, d := range deltas {
+			if i >= r.top {
+				break
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n",
+				d.name,
+				keys(d.cur), signed(keys(d.cur)-keys(d.old)),
+				humanBytes(bytes(d.cur)), signedBytes(bytes(d.cur)-bytes(d.old)),
+				growth(bytes(d.old), bytes(d.cur)))
+		}
+		tw.Flush()
+	}
+
+	if r.w.err != nil {
+		return fmt.Errorf("unable to write text diff. err=%v", r.w.err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// The distinct collector estimates the number of distinct set members, hash
+// field names and sorted set members per top level prefix, with a HyperLogLog
+// per prefix and type. Few distinct elements shared by many keys, like the
+// field names of hashes holding objects, hint at a schema.
+const (
+	// hllPrecision is the number of bits of the hashes selecting a register:
+	// 4096 registers of a byte, with a standard error of 1.6%.
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+
+	// maxDistinctPrefixes is the number of prefixes tracked per type, see
+	// boundedKey.
+	maxDistinctPrefixes = 1000
+
+	// maxDistinctReported is the number of prefixes stored in the stats.
+	maxDistinctReported = 100
+)
+
+// distinctTypes are the types whose elements are counted, and what the
+// elements are.
+var distinctTypes = map[string]string{
+	setType:       "members",
+	hashType:      "fields",
+	sortedSetType: "members",
+}
+
+// hyperLogLog estimates the number of distinct hashes added.
+type hyperLogLog [hllRegisters]uint8
+
+func (h *hyperLogLog) add(hash uint64) {
+	i := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h[i] {
+		h[i] = rank
+	}
+}
+
+func (h *hyperLogLog) count() int {
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range h {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// Linear counting, more accurate for the small cardinalities
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int(est + 0.5)
+}
+
+// hashElement returns a 64 bits hash of an element, with the bits mixed for
+// the HyperLogLog: FNV alone doesn't spread the short elements.
+func hashElement(v []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(v)
+	x := h.Sum64()
+
+	// Finalizer of MurmurHash3
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// DistinctGroup is the estimated number of distinct elements of the keys of a
+// type, in total or under a top level prefix.
+type DistinctGroup struct {
+	Prefix   string `json:",omitempty"`
+	Type     string
+	Element  string // what the elements are: members or fields
+	Keys     int
+	Elements int // of all the keys
+	Distinct int // estimated
+}
+
+// Shared returns the number of keys holding each distinct element on average.
+func (g DistinctGroup) Shared() float64 {
+	if g.Distinct == 0 {
+		return 0
+	}
+	return float64(g.Elements) / float64(g.Distinct)
+}
+
+// DistinctStats are the estimated numbers of distinct elements.
+type DistinctStats struct {
+	Types    []DistinctGroup
+	Prefixes []DistinctGroup // sorted by elements, biggest first
+}
+
+// distinctGroup counts the elements of a group of keys.
+type distinctGroup struct {
+	keys, elements int
+	hll            hyperLogLog
+}
+
+func (g *distinctGroup) result(prefix, typ string) DistinctGroup {
+	return DistinctGroup{
+		Prefix:   prefix,
+		Type:     typ,
+		Element:  distinctTypes[typ],
+		Keys:     g.keys,
+		Elements: g.elements,
+		Distinct: g.hll.count(),
+	}
+}
+
+// distinctCollector estimates the distinct elements per type and prefix.
+type distinctCollector struct {
+	delimiter string
+	types     map[string]*distinctGroup
+	prefixes  map[string]map[string]*distinctGroup // per type
+}
+
+func newDistinctCollector(delimiter string) *distinctCollector {
+	return &distinctCollector{
+		delimiter: delimiter,
+		types:     make(map[string]*distinctGroup),
+		prefixes:  make(map[string]map[string]*distinctGroup),
+	}
+}
+
+// groups returns the groups of the key k, creating them if needed.
+func (c *distinctCollector) groups(k KeyInfo) (*distinctGroup, *distinctGroup) {
+	t, ok := c.types[k.Type]
+	if !ok {
+		t = new(distinctGroup)
+		c.types[k.Type] = t
+		c.prefixes[k.Type] = make(map[string]*distinctGroup)
+	}
+
+	prefixes := c.prefixes[k.Type]
+	prefix := boundedKey(prefixes, topLevelPrefix(k.Name, c.delimiter), maxDistinctPrefixes)
+	p, ok := prefixes[prefix]
+	if !ok {
+		p = new(distinctGroup)
+		prefixes[prefix] = p
+	}
+
+	return t, p
+}
+
+func (c *distinctCollector) addElement(k KeyInfo, v []byte) {
+	h := hashElement(v)
+	t, p := c.groups(k)
+	for _, g := range []*distinctGroup{t, p} {
+		g.elements++
+		g.hll.add(h)
+	}
+}
+
+func (c *distinctCollector) addValue(k KeyInfo, v []byte) {
+	// The hashes are counted by their fields
+	if k.Type == setType || k.Type == sortedSetType {
+		c.addElement(k, v)
+	}
+}
+
+func (c *distinctCollector) addEntry(k KeyInfo, field, value []byte) {
+	c.addElement(k, field)
+}
+
+func (c *distinctCollector) add(k KeyInfo) {
+	if _, ok := distinctTypes[k.Type]; !ok {
+		return
+	}
+
+	t, p := c.groups(k)
+	t.keys++
+	p.keys++
+}
+
+func (c *distinctCollector) finish(s *Stats) {
+	var res DistinctStats
+
+	for _, typ := range keyTypes {
+		t, ok := c.types[typ]
+		if !ok {
+			continue
+		}
+		res.Types = append(res.Types, t.result("", typ))
+
+		for prefix, p := range c.prefixes[typ] {
+			res.Prefixes = append(res.Prefixes, p.result(prefix, typ))
+		}
+	}
+
+	sort.Slice(res.Prefixes, func(i, j int) bool {
+		a, b := res.Prefixes[i], res.Prefixes[j]
+		if a.Elements != b.Elements {
+			return a.Elements > b.Elements
+		}
+		if a.Prefix != b.Prefix {
+			return a.Prefix < b.Prefix
+		}
+		return typeIndex(a.Type) < typeIndex(b.Type)
+	})
+	if len(res.Prefixes) > maxDistinctReported {
+		res.Prefixes = res.Prefixes[:maxDistinctReported]
+	}
+
+	s.Distinct = &res
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	// 3 times the standard error of 1.04/sqrt(m)
+	maxError := 3 * 1.04 / math.Sqrt(hllRegisters)
+
+	var h hyperLogLog
+	if n := h.count(); n != 0 {
+		t.Errorf("got %d without elements, expected 0", n)
+	}
+
+	for _, n := range []int{10, 100, 1000, 10000, 100000, 1000000} {
+		var h hyperLogLog
+		for i := 0; i < n; i++ {
+			h.add(hashElement([]byte("element:" + strconv.Itoa(i))))
+			// The duplicates don't change the count
+			h.add(hashElement([]byte("element:" + strconv.Itoa(i/2))))
+		}
+
+		got := h.count()
+		if e := math.Abs(float64(got-n)) / float64(n); e > maxError {
+			t.Errorf("%d distinct elements: got %d, error %.3f above %.3f", n, got, e, maxError)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// The duplicates collector finds the string values stored in several keys.
+// The values are identified by a 64 bits hash, and counted in a count-min
+// sketch to find the most duplicated ones with a bounded memory: they are
+// then tracked from their second copy. The key of the first copy of the values
+// is kept while there's room, which makes the count of these values exact and
+// tells apart a second copy from a collision in the sketch; past it, the count
+// is the estimate of the sketch, which may be over the actual one. The totals
+// add the tracked values to an estimate from a sample of the other hashes: a
+// value is sampled with all its copies or not at all.
+const (
+	sketchDepth = 4
+	sketchWidth = 1 << 20 // 16 MiB of counters
+
+	// maxDuplicateCandidates is the number of values tracked as the most
+	// duplicated ones.
+	maxDuplicateCandidates = 1000
+
+	// maxDuplicateFirstKeys is the number of keys kept for the values seen
+	// once.
+	maxDuplicateFirstKeys = 100000
+
+	// maxDuplicateSample is the number of hashes sampled to estimate the
+	// totals, the sample rate is halved when it is reached.
+	maxDuplicateSample = 100000
+
+	// maxDuplicateExamples is the number of keys kept per duplicated value.
+	maxDuplicateExamples = 3
+
+	// maxDuplicatesReported is the number of duplicated values stored in the stats.
+	maxDuplicatesReported = 100
+)
+
+// countMinSketch counts the occurrences of hashes, never under the actual
+// count.
+type countMinSketch [sketchDepth][]uint32
+
+func newCountMinSketch() *countMinSketch {
+	var s countMinSketch
+	for i := range s {
+		s[i] = make([]uint32, sketchWidth)
+	}
+	return &s
+}
+
+// add counts h and returns its estimated count. Only the smallest counters
+// are incremented, which lowers the overestimation of the collisions.
+func (s *countMinSketch) add(h uint64) int {
+	var idx [sketchDepth]int
+	min := uint32(1<<32 - 1)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	for i := range s {
+		idx[i] = int((h1 + uint32(i)*h2) & (sketchWidth - 1))
+		if c := s[i][idx[i]]; c < min {
+			min = c
+		}
+	}
+
+	if min == 1<<32-1 {
+		return int(min)
+	}
+	min++
+	for i, j := range idx {
+		if s[i][j] < min {
+			s[i][j] = min
+		}
+	}
+	return int(min)
+}
+
+// DuplicateValue is a value stored in several keys. The value itself is only
+// shown by its first bytes, if enabled with -duplicates-preview.
+type DuplicateValue struct {
+	Hash        string // FNV-1a 64 bits of the value
+	Size        int
+	Count       int // estimated copies
+	WastedBytes int // of the copies after the first one
+	Class       string
+	Preview     string   `json:",omitempty"`
+	Keys        []string // examples
+}
+
+// DuplicateStats are the string values stored in several keys.
+type DuplicateStats struct {
+	Values int // strings seen, the empty ones excepted
+	Bytes  int
+
+	// Estimated from a sample of the values, unless Exact
+	DuplicatedValues int // distinct values stored in several keys
+	DuplicateKeys    int // keys storing them
+	WastedBytes      int
+	Exact            bool
+
+	Top []DuplicateValue // sorted by wasted bytes, biggest first
+}
+
+// duplicateCandidate is a value tracked as one of the most duplicated ones.
+type duplicateCandidate struct {
+	hash    uint64
+	size    int
+	count   int // estimated by the sketch if the first key is unknown, then counted
+	class   string
+	preview string
+	keys    []string
+	index   int // in the heap
+}
+
+func (c *duplicateCandidate) wasted() int {
+	return (c.count - 1) * c.size
+}
+
+// sampledValue counts the copies of a sampled value.
+type sampledValue struct {
+	count, size int
+}
+
+// duplicatesCollector counts the copies of the string values.
+type duplicatesCollector struct {
+	preview int // bytes of the values kept, none if 0
+
+	values, bytes int
+
+	sketch     *countMinSketch
+	candidates map[uint64]*duplicateCandidate
+	heap       minHeap[*duplicateCandidate] // on the wasted bytes
+	firstKeys  map[uint64]string            // of the values seen once
+
+	// The hashes whose level highest bits are 0 are sampled
+	level   uint
+	sampled map[uint64]sampledValue
+}
+
+func newDuplicatesCollector(preview int) *duplicatesCollector {
+	return &duplicatesCollector{
+		preview:    preview,
+		sketch:     newCountMinSketch(),
+		candidates: make(map[uint64]*duplicateCandidate),
+		heap: minHeap[*duplicateCandidate]{
+			less:  func(a, b *duplicateCandidate) bool { return a.wasted() < b.wasted() },
+			index: func(c *duplicateCandidate, i int) { c.index = i },
+		},
+		firstKeys: make(map[uint64]string),
+		sampled:   make(map[uint64]sampledValue),
+	}
+}
+
+func (c *duplicatesCollector) addValue(k KeyInfo, v []byte) {
+	if k.Type != stringType || len(v) == 0 {
+		return
+	}
+
+	c.values++
+	c.bytes += len(v)
+
+	hash := fnv.New64a()
+	hash.Write(v)
+	h := hash.Sum64()
+
+	c.sample(h, len(v))
+
+	count := c.sketch.add(h)
+	if cand, ok := c.candidates[h]; ok {
+		cand.count++
+		if len(cand.keys) < maxDuplicateExamples {
+			cand.keys = append(cand.keys, k.Name)
+		}
+		heap.Fix(&c.heap, cand.index)
+		return
+	}
+
+	// A value whose first key is unknown while there's room for it is seen
+	// for the first time, even if it collides in the sketch
+	first, known := c.firstKeys[h]
+	if count < 2 || (!known && len(c.firstKeys) < maxDuplicateFirstKeys) {
+		if len(c.firstKeys) < maxDuplicateFirstKeys {
+			c.firstKeys[h] = k.Name
+		}
+		return
+	}
+	delete(c.firstKeys, h)
+
+	cand := &duplicateCandidate{hash: h, size: len(v), count: count, keys: []string{k.Name}}
+	if known {
+		cand.count = 2
+		cand.keys = []string{first, k.Name}
+	}
+	if len(c.heap.items) >= maxDuplicateCandidates {
+		if cand.wasted() <= c.heap.items[0].wasted() {
+			return
+		}
+		delete(c.candidates, heap.Pop(&c.heap).(*duplicateCandidate).hash)
+	}
+
+	cand.class = classifyValue(v)
+	if c.preview > 0 {
+		p := v
+		if len(p) > c.preview {
+			p = p[:c.preview]
+		}
+		cand.preview = string(p)
+	}
+	c.candidates[h] = cand
+	heap.Push(&c.heap, cand)
+}
+
+// sample counts the value of hash h if it's sampled.
+func (c *duplicatesCollector) sample(h uint64, size int) {
+	if h>>(64-c.level) != 0 {
+		return
+	}
+
+	s := c.sampled[h]
+	s.count++
+	s.size = size
+	c.sampled[h] = s
+
+	for len(c.sampled) > maxDuplicateSample {
+		c.level++
+		for h := range c.sampled {
+			if h>>(64-c.level) != 0 {
+				delete(c.sampled, h)
+			}
+		}
+	}
+}
+
+func (c *duplicatesCollector) add(k KeyInfo) {}
+
+func (c *duplicatesCollector) finish(s *Stats) {
+	res := DuplicateStats{
+		Values: c.values,
+		Bytes:  c.bytes,
+		Exact:  c.level == 0,
+	}
+
+	for h, v := range c.sampled {
+		if _, ok := c.candidates[h]; ok || v.count < 2 {
+			continue
+		}
+		res.DuplicatedValues++
+		res.DuplicateKeys += v.count
+		res.WastedBytes += (v.count - 1) * v.size
+	}
+	res.DuplicatedValues <<= c.level
+	res.DuplicateKeys <<= c.level
+	res.WastedBytes <<= c.level
+
+	for _, cand := range c.heap.items {
+		res.DuplicatedValues++
+		res.DuplicateKeys += cand.count
+		res.WastedBytes += cand.wasted()
+
+		res.Top = append(res.Top, DuplicateValue{
+			Hash:        fmt.Sprintf("%016x", cand.hash),
+			Size:        cand.size,
+			Count:       cand.count,
+			WastedBytes: cand.wasted(),
+			Class:       cand.class,
+			Preview:     cand.preview,
+			Keys:        cand.keys,
+		})
+	}
+	sort.Slice(res.Top, func(i, j int) bool {
+		a, b := res.Top[i], res.Top[j]
+		if a.WastedBytes != b.WastedBytes {
+			return a.WastedBytes > b.WastedBytes
+		}
+		return a.Hash < b.Hash
+	})
+	if len(res.Top) > maxDuplicatesReported {
+		res.Top = res.Top[:maxDuplicatesReported]
+	}
+
+	s.Duplicates = &res
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string // the value of the key i is values[i]
+		want   []DuplicateValue
+	}{
+		{
+			name:   "unique",
+			values: []string{"a", "b", "c"},
+		},
+		{
+			name:   "2 copies",
+			values: []string{"value", "other", "value"},
+			want:   []DuplicateValue{{Size: 5, Count: 2, WastedBytes: 5, Keys: []string{"k0", "k2"}}},
+		},
+		{
+			name:   "3 copies",
+			values: []string{"value", "value", "other", "value"},
+			want:   []DuplicateValue{{Size: 5, Count: 3, WastedBytes: 10, Keys: []string{"k0", "k1", "k3"}}},
+		},
+		{
+			name:   "sorted on the wasted bytes",
+			values: []string{"ab", "abcde", "ab", "abcde", "ab"},
+			want: []DuplicateValue{
+				{Size: 5, Count: 2, WastedBytes: 5, Keys: []string{"k1", "k3"}},
+				{Size: 2, Count: 3, WastedBytes: 4, Keys: []string{"k0", "k2", "k4"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c := newDuplicatesCollector(0)
+		for i, v := range tt.values {
+			c.addValue(KeyInfo{Name: fmt.Sprintf("k%d", i), Type: stringType}, []byte(v))
+		}
+
+		var s Stats
+		c.finish(&s)
+
+		var got []DuplicateValue
+		for _, v := range s.Duplicates.Top {
+			got = append(got, DuplicateValue{Size: v.Size, Count: v.Count, WastedBytes: v.WastedBytes, Keys: v.Keys})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, expected %+v", tt.name, got, tt.want)
+		}
+
+		var keys, wasted int
+		for _, v := range tt.want {
+			keys += v.Count
+			wasted += v.WastedBytes
+		}
+		d := s.Duplicates
+		if d.DuplicatedValues != len(tt.want) || d.DuplicateKeys != keys || d.WastedBytes != wasted || !d.Exact {
+			t.Errorf("%s: unexpected totals %+v", tt.name, d)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The encodings of the values in the RDB file. The compact encodings, like
+// listpack or intset, are used by Redis while a collection has few small
+// elements and use several times less memory than the full encodings.
+const (
+	rawEncoding        = "raw"
+	intEncoding        = "int"
+	linkedListEncoding = "linkedlist"
+	ziplistEncoding    = "ziplist"
+	quicklistEncoding  = "quicklist"
+	hashtableEncoding  = "hashtable"
+	intsetEncoding     = "intset"
+	listpackEncoding   = "listpack"
+	zipmapEncoding     = "zipmap"
+	skiplistEncoding   = "skiplist"
+	streamEncoding     = "stream"
+
+	unknownEncoding = "unknown" // files read by rdbtools
+)
+
+const (
+	// defaultEncodingMargin is the proportion above a threshold within which
+	// the collections are reported.
+	defaultEncodingMargin = 0.25
+
+	// maxNearThresholdKeys is the number of keys kept per threshold.
+	maxNearThresholdKeys = 10
+)
+
+// thresholdKind is what a threshold limits.
+type thresholdKind int
+
+const (
+	entriesThreshold        thresholdKind = iota // the number of elements
+	valueThreshold                               // the byte size of each element
+	integerEntriesThreshold                      // the number of elements, all integers
+	nodeSizeThreshold                            // the size of the nodes of a list
+)
+
+// encodingThreshold is a setting of Redis limiting the size of the
+// collections in a compact encoding. A collection is in the compact encoding
+// if it is within all the thresholds of this encoding.
+type encodingThreshold struct {
+	setting string
+	typ     string
+	kind    thresholdKind
+	full    string // the encoding of the collections exceeding the threshold
+	compact string
+	limit   int
+}
+
+// defaultEncodingThresholds are the thresholds of Redis 7.
+var defaultEncodingThresholds = []encodingThreshold{
+	{"hash-max-listpack-entries", hashType, entriesThreshold, hashtableEncoding, listpackEncoding, 128},
+	{"hash-max-listpack-value", hashType, valueThreshold, hashtableEncoding, listpackEncoding, 64},
+	{"set-max-intset-entries", setType, integerEntriesThreshold, hashtableEncoding, intsetEncoding, 512},
+	{"set-max-listpack-entries", setType, entriesThreshold, hashtableEncoding, listpackEncoding, 128},
+	{"set-max-listpack-value", setType, valueThreshold, hashtableEncoding, listpackEncoding, 64},
+	{"zset-max-listpack-entries", sortedSetType, entriesThreshold, skiplistEncoding, listpackEncoding, 128},
+	{"zset-max-listpack-value", sortedSetType, valueThreshold, skiplistEncoding, listpackEncoding, 64},
+	{"list-max-listpack-size", listType, nodeSizeThreshold, quicklistEncoding, listpackEncoding, -2},
+}
+
+// parseEncodingThresholds returns the default thresholds overridden by the
+// comma separated list of setting=value of s. The names of the settings
+// before Redis 7, with ziplist instead of listpack, are accepted.
+func parseEncodingThresholds(s string) ([]encodingThreshold, error) {
+	res := make([]encodingThreshold, len(defaultEncodingThresholds))
+	copy(res, defaultEncodingThresholds)
+
+	for _, e := range splitList(s) {
+		i := strings.Index(e, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid encoding threshold %q, expected setting=value", e)
+		}
+		setting := strings.Replace(strings.TrimSpace(e[:i]), "-ziplist-", "-listpack-", 1)
+		limit, err := strconv.Atoi(strings.TrimSpace(e[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of encoding threshold %q", e)
+		}
+
+		var found bool
+		for j := range res {
+			if res[j].setting != setting {
+				continue
+			}
+			if !res[j].valid(limit) {
+				return nil, fmt.Errorf("invalid value of encoding threshold %q", e)
+			}
+			res[j].limit = limit
+			found = true
+		}
+		if !found {
+			var names []string
+			for _, t := range defaultEncodingThresholds {
+				names = append(names, t.setting)
+			}
+			return nil, fmt.Errorf("unknown encoding threshold %q, the thresholds are %s", setting, strings.Join(names, ", "))
+		}
+	}
+
+	return res, nil
+}
+
+// encodingThresholds are the thresholds of the analysis, set by
+// parseEncodingFlags.
+var encodingThresholds = defaultEncodingThresholds
+
+// parseEncodingFlags parses -encoding-thresholds into encodingThresholds and
+// checks -encoding-margin.
+func parseEncodingFlags() error {
+	thresholds, err := parseEncodingThresholds(flEncodingThresholds)
+	if err != nil {
+		return err
+	}
+	if flEncodingMargin < 0 {
+		return fmt.Errorf("invalid encoding margin %g", flEncodingMargin)
+	}
+
+	encodingThresholds = thresholds
+	return nil
+}
+
+func (t encodingThreshold) valid(limit int) bool {
+	if t.kind == nodeSizeThreshold {
+		// A number of elements, or -1 to -5 for 4 to 64 KiB
+		return limit >= -5 && limit != 0
+	}
+	return limit >= 0
+}
+
+// listNodeBytes returns the maximum byte size of a node of a list for the
+// negative values of list-max-listpack-size.
+func listNodeBytes(limit int) int {
+	return 4096 << uint(-limit-1)
+}
+
+// listNodes returns the number of nodes of the list k with the
+// list-max-listpack-size limit.
+func listNodes(k KeyInfo, limit int) int {
+	if limit > 0 {
+		return (k.Length + limit - 1) / limit
+	}
+	size := listpackSize(k)
+	return (size + listNodeBytes(limit) - 1) / listNodeBytes(limit)
+}
+
+// listpackSize estimates the byte size of the collection k as a listpack.
+func listpackSize(k KeyInfo) int {
+	return listpackHeaderSize + k.Size + k.Length*listpackEntryOverhead
+}
+
+// within reports whether the collection k is within the threshold, with the
+// limit instead of the one of t.
+func (t encodingThreshold) within(k KeyInfo, limit int) bool {
+	switch t.kind {
+	case entriesThreshold:
+		return k.Length <= limit
+	case valueThreshold:
+		return k.MaxElement <= limit
+	case integerEntriesThreshold:
+		return k.Integers && k.Length <= limit
+	default:
+		return listNodes(k, limit) <= 1
+	}
+}
+
+// compactEncoding returns the compact encoding of the collection k with the
+// thresholds, or an empty string if it's in the full encoding. The limit of
+// the threshold override replaces its limit if it's not empty.
+func compactEncoding(k KeyInfo, thresholds []encodingThreshold, override string, limit int) string {
+	// The encodings in the order Redis tries them
+	var encodings []string
+	fits := make(map[string]bool)
+	for _, t := range thresholds {
+		if t.typ != k.Type {
+			continue
+		}
+		if _, ok := fits[t.compact]; !ok {
+			encodings = append(encodings, t.compact)
+			fits[t.compact] = true
+		}
+
+		l := t.limit
+		if t.setting == override {
+			l = limit
+		}
+		fits[t.compact] = fits[t.compact] && t.within(k, l)
+	}
+
+	for _, e := range encodings {
+		if fits[e] {
+			return e
+		}
+	}
+	return ""
+}
+
+// stringEncoding returns the encoding of a string value: Redis stores the
+// integers as such.
+func stringEncoding(v []byte) string {
+	n, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != string(v) {
+		return rawEncoding
+	}
+	return intEncoding
+}
+
+// TypeEncoding counts the keys of a type in an encoding.
+type TypeEncoding struct {
+	Type     string
+	Encoding string
+	Keys     int
+	Bytes    int
+}
+
+// NearThreshold counts the keys in the full encoding which would fit in the
+// compact one if a threshold was raised by at most the margin.
+type NearThreshold struct {
+	Setting  string
+	Limit    int
+	Type     string
+	Encoding string // the full encoding
+	Keys     int
+	Bytes    int
+	Biggest  []KeyInfo `json:",omitempty"`
+}
+
+// EncodingStats are the stats of the encodings of the keys.
+type EncodingStats struct {
+	Encodings      []TypeEncoding // sorted by type, then by bytes
+	Margin         float64
+	NearThresholds []NearThreshold
+}
+
+// UnknownKeys returns the number of keys whose encoding is unknown. They are
+// read by rdbtools, from the files up to RDB version 6, and can't be compared
+// to the thresholds.
+func (e *EncodingStats) UnknownKeys() int {
+	var res int
+	for _, te := range e.Encodings {
+		if te.Encoding == unknownEncoding {
+			res += te.Keys
+		}
+	}
+	return res
+}
+
+// encodingCollector accounts the keys per type and encoding, and the
+// collections just above the thresholds of the compact encodings.
+type encodingCollector struct {
+	thresholds []encodingThreshold
+	margin     float64
+
+	encodings map[[2]string]*TypeEncoding
+	near      []NearThreshold
+	biggest   []topKeys
+}
+
+func newEncodingCollector(thresholds []encodingThreshold, margin float64) *encodingCollector {
+	c := &encodingCollector{
+		margin:    margin,
+		encodings: make(map[[2]string]*TypeEncoding),
+	}
+	for _, t := range thresholds {
+		// The lists are always split in nodes, there's no full encoding to avoid
+		if t.kind == nodeSizeThreshold {
+			continue
+		}
+		c.thresholds = append(c.thresholds, t)
+		c.near = append(c.near, NearThreshold{Setting: t.setting, Limit: t.limit, Type: t.typ, Encoding: t.full})
+		c.biggest = append(c.biggest, *newTopKeys(maxNearThresholdKeys))
+	}
+	return c
+}
+
+// raised returns limit raised by the margin.
+func (c *encodingCollector) raised(limit int) int {
+	return int(float64(limit) * (1 + c.margin))
+}
+
+func (c *encodingCollector) add(k KeyInfo) {
+	encoding := k.Encoding
+	if encoding == "" {
+		encoding = unknownEncoding
+	}
+
+	id := [2]string{k.Type, encoding}
+	e, ok := c.encodings[id]
+	if !ok {
+		e = &TypeEncoding{Type: k.Type, Encoding: encoding}
+		c.encodings[id] = e
+	}
+	e.Keys++
+	e.Bytes += k.Size
+
+	// The key would be compact if all the thresholds of one of the compact
+	// encodings of its type it exceeds were raised by the margin.
+	near := make(map[string]bool)
+	exceeded := make(map[string][]int)
+	for i, t := range c.thresholds {
+		if t.typ != k.Type || t.full != k.Encoding {
+			continue
+		}
+		if _, ok := near[t.compact]; !ok {
+			near[t.compact] = true
+		}
+
+		if !t.within(k, c.raised(t.limit)) {
+			near[t.compact] = false
+		} else if !t.within(k, t.limit) {
+			exceeded[t.compact] = append(exceeded[t.compact], i)
+		}
+	}
+
+	for compact, ok := range near {
+		if !ok {
+			continue
+		}
+		for _, i := range exceeded[compact] {
+			c.near[i].Keys++
+			c.near[i].Bytes += k.Size
+			c.biggest[i].add(k)
+		}
+	}
+}
+
+func (c *encodingCollector) finish(s *Stats) {
+	res := EncodingStats{Margin: c.margin}
+
+	for _, e := range c.encodings {
+		res.Encodings = append(res.Encodings, *e)
+	}
+	sort.Slice(res.Encodings, func(i, j int) bool {
+		a, b := res.Encodings[i], res.Encodings[j]
+		if a.Type != b.Type {
+			return typeIndex(a.Type) < typeIndex(b.Type)
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Encoding < b.Encoding
+	})
+
+	for i, n := range c.near {
+		n.Biggest = c.biggest[i].sorted()
+		res.NearThresholds = append(res.NearThresholds, n)
+	}
+
+	s.Encodings = &res
+}
+
+// typeIndex returns the position of the type t in keyTypes.
+func typeIndex(t string) int {
+	for i, kt := range keyTypes {
+		if kt == t {
+			return i
+		}
+	}
+	return len(keyTypes)
+}
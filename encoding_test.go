@@ -0,0 +1,127 @@
+package main
+
+import "testing"
+
+func TestParseEncodingThresholds(t *testing.T) {
+	limit := func(thresholds []encodingThreshold, setting string) int {
+		for _, th := range thresholds {
+			if th.setting == setting {
+				return th.limit
+			}
+		}
+		t.Fatalf("no threshold %s", setting)
+		return 0
+	}
+
+	res, err := parseEncodingThresholds("")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(res) != len(defaultEncodingThresholds) {
+		t.Fatalf("got %d thresholds, expected %d", len(res), len(defaultEncodingThresholds))
+	}
+
+	res, err = parseEncodingThresholds(" hash-max-listpack-entries = 512, zset-max-ziplist-value=128,")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := limit(res, "hash-max-listpack-entries"); n != 512 {
+		t.Errorf("got hash-max-listpack-entries %d, expected 512", n)
+	}
+	if n := limit(res, "zset-max-listpack-value"); n != 128 {
+		t.Errorf("got zset-max-listpack-value %d, expected 128", n)
+	}
+	if n := limit(res, "set-max-listpack-entries"); n != 128 {
+		t.Errorf("got set-max-listpack-entries %d, expected the default 128", n)
+	}
+	if n := limit(defaultEncodingThresholds, "hash-max-listpack-entries"); n != 128 {
+		t.Errorf("the defaults were changed, got hash-max-listpack-entries %d", n)
+	}
+
+	for _, s := range []string{
+		"hash-max-listpack-entries",
+		"hash-max-listpack-entries=",
+		"hash-max-listpack-entries=many",
+		"hash-max-listpack-entries=-1",
+		"list-max-entries=10",
+	} {
+		if _, err := parseEncodingThresholds(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestEncodingCollector(t *testing.T) {
+	c := newEncodingCollector(defaultEncodingThresholds, 0.25)
+	for _, k := range []KeyInfo{
+		{Name: "compact", Type: hashType, Encoding: listpackEncoding, Length: 10, MaxElement: 10, Size: 100},
+		{Name: "near", Type: hashType, Encoding: hashtableEncoding, Length: 150, MaxElement: 10, Size: 1500},
+		{Name: "far", Type: hashType, Encoding: hashtableEncoding, Length: 1000, MaxElement: 10, Size: 10000},
+		{Name: "both", Type: hashType, Encoding: hashtableEncoding, Length: 150, MaxElement: 70, Size: 2000},
+		{Name: "unknown", Type: hashType, Length: 150, MaxElement: 10, Size: 1500},
+	} {
+		c.add(k)
+	}
+
+	var s Stats
+	c.finish(&s)
+	e := s.Encodings
+
+	for _, n := range e.NearThresholds {
+		var keys int
+		switch n.Setting {
+		case "hash-max-listpack-entries":
+			keys = 2
+		case "hash-max-listpack-value":
+			keys = 1
+		}
+		if n.Keys != keys {
+			t.Errorf("got %d keys near %s, expected %d", n.Keys, n.Setting, keys)
+		}
+	}
+	if n := e.UnknownKeys(); n != 1 {
+		t.Errorf("got %d keys of unknown encoding, expected 1", n)
+	}
+	if te := e.Encodings[0]; te.Encoding != hashtableEncoding || te.Keys != 3 {
+		t.Errorf("got %+v first, expected the 3 hashtable hashes", te)
+	}
+}
+
+func TestCompactEncoding(t *testing.T) {
+	tests := []struct {
+		key      KeyInfo
+		override string
+		limit    int
+		encoding string
+	}{
+		{key: KeyInfo{Type: hashType, Length: 128, MaxElement: 64}, encoding: listpackEncoding},
+		{key: KeyInfo{Type: hashType, Length: 129, MaxElement: 10}},
+		{key: KeyInfo{Type: hashType, Length: 10, MaxElement: 65}},
+		{key: KeyInfo{Type: hashType, Length: 129, MaxElement: 10}, override: "hash-max-listpack-entries", limit: 256, encoding: listpackEncoding},
+		{key: KeyInfo{Type: hashType, Length: 10, MaxElement: 10}, override: "hash-max-listpack-entries", limit: 5},
+
+		// The integer sets are intsets up to set-max-intset-entries, then
+		// listpacks if they are small enough
+		{key: KeyInfo{Type: setType, Length: 500, MaxElement: 8, Integers: true}, encoding: intsetEncoding},
+		{key: KeyInfo{Type: setType, Length: 100, MaxElement: 8, Integers: false}, encoding: listpackEncoding},
+		{key: KeyInfo{Type: setType, Length: 600, MaxElement: 8, Integers: true}},
+		{key: KeyInfo{Type: setType, Length: 100, MaxElement: 8, Integers: true}, override: "set-max-intset-entries", limit: 50, encoding: listpackEncoding},
+
+		{key: KeyInfo{Type: sortedSetType, Length: 10, MaxElement: 10}, encoding: listpackEncoding},
+		{key: KeyInfo{Type: sortedSetType, Length: 10, MaxElement: 100}},
+
+		// A single node of 8 KiB by default
+		{key: KeyInfo{Type: listType, Length: 10, Size: 100}, encoding: listpackEncoding},
+		{key: KeyInfo{Type: listType, Length: 10, Size: 10000}},
+		{key: KeyInfo{Type: listType, Length: 10, Size: 10000}, override: "list-max-listpack-size", limit: -3, encoding: listpackEncoding},
+		{key: KeyInfo{Type: listType, Length: 10, Size: 100}, override: "list-max-listpack-size", limit: 5},
+
+		{key: KeyInfo{Type: stringType, Length: 1, Size: 10}},
+	}
+
+	for _, tt := range tests {
+		if got := compactEncoding(tt.key, defaultEncodingThresholds, tt.override, tt.limit); got != tt.encoding {
+			t.Errorf("%+v with %s=%d: got %q, expected %q", tt.key, tt.override, tt.limit, got, tt.encoding)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// The hash fields collector accounts the fields of the hashes per key
+// pattern: how many hashes have each field and the bytes of its name and its
+// values. The field names which look like identifiers are replaced by a
+// wildcard, like the key prefixes.
+const (
+	// maxHashPatterns is the number of key patterns tracked, see boundedKey.
+	maxHashPatterns = 1000
+
+	// maxHashFields is the number of fields tracked per pattern. Once reached,
+	// a new field replaces the one using the least bytes and inherits its
+	// bytes: the bytes of the new fields are overestimated, but the fields
+	// using the most bytes are kept.
+	maxHashFields = 256
+
+	// maxHashPatternsReported and maxHashFieldsReported bound the patterns
+	// and the fields per pattern stored in the stats.
+	maxHashPatternsReported = 100
+	maxHashFieldsReported   = 20
+)
+
+// HashField is a field of the hashes of a key pattern.
+type HashField struct {
+	Name       string
+	Keys       int // hashes having the field
+	NameBytes  int
+	ValueBytes int
+	MaxValue   int  // bytes of the biggest value
+	Estimated  bool `json:",omitempty"` // includes the bytes of evicted fields
+}
+
+// Bytes returns the bytes of the names and the values of the field.
+func (f HashField) Bytes() int {
+	return f.NameBytes + f.ValueBytes
+}
+
+// HashPattern are the fields of the hashes of a key pattern.
+type HashPattern struct {
+	Pattern   string
+	Keys      int
+	Bytes     int
+	Truncated bool        // more fields than tracked were seen
+	Fields    []HashField // sorted by bytes, biggest first
+}
+
+// HashFieldStats are the fields of the hashes per key pattern.
+type HashFieldStats struct {
+	Patterns []HashPattern // sorted by bytes, biggest first
+}
+
+// trackedField is a field tracked in a pattern.
+type trackedField struct {
+	HashField
+	index int // in the heap
+}
+
+// hashPattern accounts the fields of a pattern.
+type hashPattern struct {
+	keys, bytes int
+	truncated   bool
+	fields      map[string]*trackedField
+	heap        minHeap[*trackedField] // on the bytes
+}
+
+// field returns the tracked field named name, evicting the field using the
+// least bytes if there are too many.
+func (p *hashPattern) field(name string) *trackedField {
+	if f, ok := p.fields[name]; ok {
+		return f
+	}
+
+	f := &trackedField{HashField: HashField{Name: name}}
+	if len(p.heap.items) >= maxHashFields {
+		min := heap.Pop(&p.heap).(*trackedField)
+		delete(p.fields, min.Name)
+		f.NameBytes = min.NameBytes
+		f.ValueBytes = min.ValueBytes
+		f.Estimated = true
+		p.truncated = true
+	}
+	p.fields[name] = f
+	heap.Push(&p.heap, f)
+	return f
+}
+
+// hashFieldsCollector accounts the fields of the hashes per key pattern.
+type hashFieldsCollector struct {
+	delimiter string
+	depth     int
+	patterns  map[string]*hashPattern
+	seen      map[string]bool // the fields of the current hash, counted once per hash
+}
+
+func newHashFieldsCollector(delimiter string, depth int) *hashFieldsCollector {
+	return &hashFieldsCollector{
+		delimiter: delimiter,
+		depth:     depth,
+		patterns:  make(map[string]*hashPattern),
+		seen:      make(map[string]bool),
+	}
+}
+
+func (c *hashFieldsCollector) pattern(key string) *hashPattern {
+	name := boundedKey(c.patterns, keyNamespace(key, c.delimiter, c.depth), maxHashPatterns)
+	p, ok := c.patterns[name]
+	if !ok {
+		p = &hashPattern{
+			fields: make(map[string]*trackedField),
+			heap: minHeap[*trackedField]{
+				less:  func(a, b *trackedField) bool { return a.Bytes() < b.Bytes() },
+				index: func(f *trackedField, i int) { f.index = i },
+			},
+		}
+		c.patterns[name] = p
+	}
+	return p
+}
+
+func (c *hashFieldsCollector) addEntry(k KeyInfo, field, value []byte) {
+	name := string(field)
+	if isIdentifier(name) {
+		name = wildcardPrefix
+	}
+
+	p := c.pattern(k.Name)
+	f := p.field(name)
+	if !c.seen[name] {
+		c.seen[name] = true
+		f.Keys++
+	}
+	f.NameBytes += len(field)
+	f.ValueBytes += len(value)
+	if len(value) > f.MaxValue {
+		f.MaxValue = len(value)
+	}
+	heap.Fix(&p.heap, f.index)
+}
+
+func (c *hashFieldsCollector) add(k KeyInfo) {
+	for name := range c.seen {
+		delete(c.seen, name)
+	}
+
+	if k.Type != hashType {
+		return
+	}
+
+	p := c.pattern(k.Name)
+	p.keys++
+	p.bytes += k.Size
+}
+
+func (c *hashFieldsCollector) finish(s *Stats) {
+	var res HashFieldStats
+
+	for name, p := range c.patterns {
+		hp := HashPattern{Pattern: name, Keys: p.keys, Bytes: p.bytes, Truncated: p.truncated}
+		for _, f := range p.heap.items {
+			hp.Fields = append(hp.Fields, f.HashField)
+		}
+		sort.Slice(hp.Fields, func(i, j int) bool {
+			a, b := hp.Fields[i], hp.Fields[j]
+			if a.Bytes() != b.Bytes() {
+				return a.Bytes() > b.Bytes()
+			}
+			return a.Name < b.Name
+		})
+		if len(hp.Fields) > maxHashFieldsReported {
+			hp.Fields = hp.Fields[:maxHashFieldsReported]
+		}
+		res.Patterns = append(res.Patterns, hp)
+	}
+
+	sort.Slice(res.Patterns, func(i, j int) bool {
+		a, b := res.Patterns[i], res.Patterns[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Pattern < b.Pattern
+	})
+	if len(res.Patterns) > maxHashPatternsReported {
+		res.Patterns = res.Patterns[:maxHashPatternsReported]
+	}
+
+	s.HashFields = &res
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashPatternFieldEviction(t *testing.T) {
+	c := newHashFieldsCollector(":", 1)
+	k := KeyInfo{Name: "user:1", Type: hashType, Size: 100}
+
+	// The field fN has a value of N+1 bytes, f0 uses the least bytes
+	for i := 0; i < maxHashFields; i++ {
+		c.addEntry(k, []byte("f"+strconv.Itoa(i)), []byte(strings.Repeat("v", i+1)))
+	}
+	c.addEntry(k, []byte("new"), []byte("v"))
+	c.add(k)
+
+	p := c.patterns["user:*"]
+	if !p.truncated {
+		t.Errorf("the pattern isn't truncated")
+	}
+	if len(p.fields) != maxHashFields || len(p.heap.items) != maxHashFields {
+		t.Fatalf("got %d fields and %d in the heap, expected %d", len(p.fields), len(p.heap.items), maxHashFields)
+	}
+	if _, ok := p.fields["f0"]; ok {
+		t.Errorf("the field using the least bytes wasn't evicted")
+	}
+
+	f, ok := p.fields["new"]
+	if !ok {
+		t.Fatalf("the new field isn't tracked")
+	}
+	// The 3 bytes of f0 and its own 4 bytes
+	if !f.Estimated || f.Bytes() != 7 || f.Keys != 1 {
+		t.Errorf("got %+v, expected 7 estimated bytes in 1 hash", f.HashField)
+	}
+	if f := p.fields["f1"]; f.Estimated || f.Bytes() != 4 {
+		t.Errorf("got %+v, expected 4 exact bytes", f.HashField)
+	}
+}
+
+func TestHashFieldsCollector(t *testing.T) {
+	c := newHashFieldsCollector(":", 1)
+	for i := 0; i < 3; i++ {
+		k := KeyInfo{Name: "user:" + strconv.Itoa(i), Type: hashType, Size: 50}
+		c.addEntry(k, []byte("name"), []byte("alice"))
+		c.addEntry(k, []byte("42"), []byte("x"))
+		c.addEntry(k, []byte("43"), []byte("y"))
+		c.add(k)
+	}
+	c.add(KeyInfo{Name: "plain", Type: stringType})
+
+	var s Stats
+	c.finish(&s)
+
+	if len(s.HashFields.Patterns) != 1 {
+		t.Fatalf("got %d patterns, expected 1", len(s.HashFields.Patterns))
+	}
+	p := s.HashFields.Patterns[0]
+	if p.Pattern != "user:*" || p.Keys != 3 || p.Bytes != 150 || p.Truncated {
+		t.Errorf("got the pattern %+v", p)
+	}
+
+	// The identifiers are grouped but counted once per hash
+	for _, f := range p.Fields {
+		if f.Keys != 3 {
+			t.Errorf("%s: got %d hashes, expected 3", f.Name, f.Keys)
+		}
+	}
+	if f := p.Fields[0]; f.Name != "name" || f.NameBytes != 12 || f.ValueBytes != 15 || f.MaxValue != 5 {
+		t.Errorf("got %+v first, expected the name field", f)
+	}
+	if f := p.Fields[1]; f.Name != wildcardPrefix || f.NameBytes != 12 || f.ValueBytes != 6 {
+		t.Errorf("got %+v second, expected the identifiers", f)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// job is the analysis of an uploaded RDB file running in the background.
+type job struct {
+	id       string
+	filename string
+	size     int64
+	created  time.Time
+
+	read int64 // number of bytes read by the parser, accessed atomically
+
+	mu       sync.Mutex
+	state    string
+	err      error
+	finished time.Time
+	handler  http.Handler
+}
+
+// jobStatus is the representation of a job in the API.
+type jobStatus struct {
+	ID       string
+	Filename string
+	Size     int64
+	State    string
+	Progress float64
+	Error    string `json:",omitempty"`
+	Report   string `json:",omitempty"`
+	Created  time.Time
+	Duration string `json:",omitempty"`
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	st := jobStatus{
+		ID:       j.id,
+		Filename: j.filename,
+		Size:     j.size,
+		State:    j.state,
+		Created:  j.created,
+	}
+
+	switch j.state {
+	case jobRunning:
+		if j.size > 0 {
+			st.Progress = float64(atomic.LoadInt64(&j.read)) / float64(j.size) * 100
+		}
+	case jobDone:
+		st.Progress = 100
+		st.Report = "/reports/" + j.id + "/"
+		st.Duration = j.finished.Sub(j.created).String()
+	case jobFailed:
+		st.Error = j.err.Error()
+		st.Duration = j.finished.Sub(j.created).String()
+	}
+
+	return st
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// jobManager runs the analysis jobs and keeps the finished ones.
+//
+// At most maxJobs jobs run concurrently and at most maxReports finished jobs
+// are kept: once the limit is reached the oldest one is evicted.
+type jobManager struct {
+	maxJobs    int
+	maxReports int
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	running  int
+	finished []string // oldest first
+}
+
+func newJobManager(maxJobs, maxReports int) *jobManager {
+	return &jobManager{
+		maxJobs:    maxJobs,
+		maxReports: maxReports,
+		jobs:       make(map[string]*job),
+	}
+}
+
+// reserve reserves a slot for a new job. It returns false if too many jobs are running.
+func (m *jobManager) reserve() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running >= m.maxJobs {
+		return false
+	}
+	m.running++
+
+	return true
+}
+
+// release releases a slot reserved with reserve.
+func (m *jobManager) release() {
+	m.mu.Lock()
+	m.running--
+	m.mu.Unlock()
+}
+
+func (m *jobManager) get(id string) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.jobs[id]
+}
+
+// start starts the analysis of the file at path in a slot previously
+// reserved. The file is removed once the job is finished.
+func (m *jobManager) start(filename, path string, size int64) *job {
+	j := &job{
+		id:       newJobID(),
+		filename: filename,
+		size:     size,
+		created:  time.Now(),
+		state:    jobRunning,
+	}
+
+	m.mu.Lock()
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+
+	go m.run(j, path)
+
+	return j
+}
+
+func (m *jobManager) run(j *job, path string) {
+	defer os.Remove(path)
+
+	var s Stats
+
+	f, err := os.Open(path)
+	if err == nil {
+		err = analyzeRDB(countingReader{r: f, n: &j.read}, &s)
+		f.Close()
+	}
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	if err != nil {
+		j.state = jobFailed
+		j.err = err
+	} else {
+		j.state = jobDone
+		j.handler = http.StripPrefix("/reports/"+j.id, newServer(&s, nil))
+	}
+	j.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running--
+	m.finished = append(m.finished, j.id)
+	for len(m.finished) > m.maxReports {
+		delete(m.jobs, m.finished[0])
+		m.finished = m.finished[1:]
+	}
+}
+
+func newJobID() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+var uploadTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Analyze a RDB file</title></head>
+<body>
+<h1>Analyze a RDB file</h1>
+<p>The file can be compressed with gzip or bzip2. Maximum size: {{.}} bytes.</p>
+<form method="POST" action="/analyze" enctype="multipart/form-data">
+<input type="file" name="file">
+<input type="submit" value="Analyze">
+</form>
+</body>
+</html>
+`))
+
+var jobTemplate = template.Must(template.New("job").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Analysis of {{.Filename}}</title>
+{{if eq .State "running"}}<meta http-equiv="refresh" content="2">{{end}}
+</head>
+<body>
+<h1>Analysis of {{.Filename}}</h1>
+{{if eq .State "running"}}<p>Running: {{printf "%.1f" .Progress}}%</p>{{end}}
+{{if eq .State "failed"}}<p>Failed after {{.Duration}}: {{.Error}}</p>{{end}}
+{{if eq .State "done"}}<p>Done in {{.Duration}}: <a href="{{.Report}}">see the report</a></p>{{end}}
+</body>
+</html>
+`))
+
+func wantsHTML(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+func (s *server) handleAnalyze(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		var buf bytes.Buffer
+		if err := uploadTemplate.Execute(&buf, flMaxUploadSize); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.Copy(w, &buf)
+		return
+	case "POST":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.jobs.reserve() {
+		http.Error(w, "too many analysis running, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, flMaxUploadSize)
+
+	filename, path, size, err := saveUpload(req)
+	if err != nil {
+		s.jobs.release()
+
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("file too large, the maximum size is %d bytes", flMaxUploadSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j := s.jobs.start(filename, path, size)
+	location := "/jobs/" + j.id
+
+	if wantsHTML(req) {
+		http.Redirect(w, req, location, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Location", location)
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+// saveUpload saves the uploaded file in a temporary file. The file is either
+// the "file" field of a multipart form or the whole request body.
+func saveUpload(req *http.Request) (filename, path string, size int64, err error) {
+	var r io.Reader
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		mr, err := req.MultipartReader()
+		if err != nil {
+			return "", "", 0, err
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", "", 0, errors.New("no file uploaded")
+			}
+			if err != nil {
+				return "", "", 0, err
+			}
+
+			if part.FormName() == "file" {
+				filename = part.FileName()
+				r = part
+				break
+			}
+		}
+	} else {
+		filename = req.URL.Query().Get("name")
+		r = req.Body
+	}
+
+	if filename == "" {
+		filename = "upload.rdb"
+	}
+
+	f, err := ioutil.TempFile("", "rdbanalyzer-")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("unable to create temporary file. err=%v", err)
+	}
+	defer f.Close()
+
+	size, err = io.Copy(f, r)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", 0, err
+	}
+
+	return filename, f.Name(), size, nil
+}
+
+func (s *server) handleJob(w http.ResponseWriter, req *http.Request) {
+	j := s.jobs.get(strings.TrimPrefix(req.URL.Path, "/jobs/"))
+	if j == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	st := j.status()
+
+	if !wantsHTML(req) {
+		writeJSON(w, http.StatusOK, st)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := jobTemplate.Execute(&buf, st); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.Copy(w, &buf)
+}
+
+func (s *server) handleReport(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/reports/")
+
+	id := path
+	if i := strings.Index(path, "/"); i >= 0 {
+		id = path[:i]
+	}
+
+	j := s.jobs.get(id)
+	if j == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	j.mu.Lock()
+	handler := j.handler
+	j.mu.Unlock()
+
+	switch {
+	case handler == nil:
+		http.Redirect(w, req, "/jobs/"+id, http.StatusSeeOther)
+	case path == id:
+		http.Redirect(w, req, "/reports/"+id+"/", http.StatusMovedPermanently)
+	default:
+		handler.ServeHTTP(w, req)
+	}
+}
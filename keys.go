@@ -0,0 +1,278 @@
+package main
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	stringType    = "string"
+	listType      = "list"
+	setType       = "set"
+	hashType      = "hash"
+	sortedSetType = "zset"
+	streamType    = "stream"
+	moduleType    = "module"
+)
+
+// keyTypes lists the types of keys, in the order they are reported.
+var keyTypes = []string{stringType, listType, setType, hashType, sortedSetType, streamType, moduleType}
+
+// KeyInfo describes a single key of the RDB file.
+type KeyInfo struct {
+	DB         int
+	Name       string
+	Type       string
+	Length     int // number of elements, 1 for strings
+	Size       int // byte size of the values
+	ExpiryTime time.Time
+
+	Encoding   string `json:",omitempty"` // in the RDB file, empty if unknown
+	MaxElement int    `json:",omitempty"` // byte size of the biggest element, field or value
+	Integers   bool   `json:",omitempty"` // all the elements of the set are integers
+}
+
+// TTLDistribution counts keys per time to live bucket.
+type TTLDistribution struct {
+	None    int
+	Expired int
+	Hour    int // less than an hour
+	Day     int // less than a day
+	Week    int // less than a week
+	Month   int // less than 30 days
+	Longer  int
+}
+
+func (d *TTLDistribution) add(expiry, now time.Time) {
+	ttl := expiry.Sub(now)
+
+	switch {
+	case expiry.IsZero():
+		d.None++
+	case ttl <= 0:
+		d.Expired++
+	case ttl < time.Hour:
+		d.Hour++
+	case ttl < 24*time.Hour:
+		d.Day++
+	case ttl < 7*24*time.Hour:
+		d.Week++
+	case ttl < 30*24*time.Hour:
+		d.Month++
+	default:
+		d.Longer++
+	}
+}
+
+// ttlBucket is a bucket of a TTLDistribution.
+type ttlBucket struct {
+	name  string
+	count int
+}
+
+func (d TTLDistribution) buckets() []ttlBucket {
+	return []ttlBucket{
+		{"no ttl", d.None},
+		{"expired", d.Expired},
+		{"< 1h", d.Hour},
+		{"< 1d", d.Day},
+		{"< 1w", d.Week},
+		{"< 30d", d.Month},
+		{">= 30d", d.Longer},
+	}
+}
+
+// dataToString converts a value sent by the parser to a string.
+//
+// Depending on the encoding in the RDB file, values are either raw bytes or integers.
+func dataToString(v interface{}) string {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	default:
+		return ""
+	}
+}
+
+// dataBytes returns a value sent by the parser as bytes: the integers are
+// sent by rdbtools as such.
+func dataBytes(v interface{}) []byte {
+	if b, ok := v.([]byte); ok {
+		return b
+	}
+	return []byte(dataToString(v))
+}
+
+// dataLen returns the byte size of a value sent by the parser.
+func dataLen(v interface{}) int {
+	if b, ok := v.([]byte); ok {
+		return len(b)
+	}
+	return len(dataToString(v))
+}
+
+// minHeap is a heap of items, the smallest first according to less. If index
+// isn't nil, it's given the position of an item each time it moves, for
+// heap.Fix.
+type minHeap[T any] struct {
+	items []T
+	less  func(a, b T) bool
+	index func(x T, i int)
+}
+
+func (h *minHeap[T]) Len() int           { return len(h.items) }
+func (h *minHeap[T]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *minHeap[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.moved(i)
+	h.moved(j)
+}
+func (h *minHeap[T]) Push(x interface{}) {
+	h.items = append(h.items, x.(T))
+	h.moved(len(h.items) - 1)
+}
+func (h *minHeap[T]) Pop() interface{} {
+	n := len(h.items) - 1
+	x := h.items[n]
+	h.items = h.items[:n]
+	return x
+}
+
+func (h *minHeap[T]) moved(i int) {
+	if h.index != nil {
+		h.index(h.items[i], i)
+	}
+}
+
+// topKeys keeps the n biggest keys seen.
+type topKeys struct {
+	n int
+	h minHeap[KeyInfo]
+}
+
+func newTopKeys(n int) *topKeys {
+	return &topKeys{
+		n: n,
+		h: minHeap[KeyInfo]{less: func(a, b KeyInfo) bool { return a.Size < b.Size }},
+	}
+}
+
+func (t *topKeys) add(k KeyInfo) {
+	switch {
+	case t.n <= 0:
+		return
+	case len(t.h.items) < t.n:
+		heap.Push(&t.h, k)
+	case k.Size > t.h.items[0].Size:
+		t.h.items[0] = k
+		heap.Fix(&t.h, 0)
+	}
+}
+
+func (t *topKeys) finish(s *Stats) {
+	s.TopKeys = t.sorted()
+}
+
+// sorted returns the keys from the biggest to the smallest.
+func (t *topKeys) sorted() []KeyInfo {
+	res := make([]KeyInfo, len(t.h.items))
+	copy(res, t.h.items)
+	sort.Slice(res, func(i, j int) bool { return res[i].Size > res[j].Size })
+
+	return res
+}
+
+// maxKeysPerType is the number of keys with the most elements and of
+// biggest keys kept per type.
+const maxKeysPerType = 10
+
+// insertKey inserts k in keys, sorted from the biggest to the smallest value,
+// if it is one of the n biggest.
+func insertKey(keys []KeyInfo, k KeyInfo, n int, value func(KeyInfo) int) []KeyInfo {
+	i := sort.Search(len(keys), func(i int) bool { return value(keys[i]) < value(k) })
+	if i >= n {
+		return keys
+	}
+	if len(keys) < n {
+		keys = append(keys, KeyInfo{})
+	}
+	copy(keys[i+1:], keys[i:])
+	keys[i] = k
+
+	return keys
+}
+
+// longestKeys keeps, for each type, the keys with the most elements.
+type longestKeys map[string][]KeyInfo
+
+func (l longestKeys) add(k KeyInfo) {
+	l[k.Type] = insertKey(l[k.Type], k, maxKeysPerType, func(k KeyInfo) int { return k.Length })
+}
+
+func (l longestKeys) finish(s *Stats) {
+	s.LongestKeys = l
+}
+
+// biggestKeys keeps, for each type, the biggest keys: unlike topKeys, the
+// biggest keys of every type are kept whatever the sizes of the other types.
+type biggestKeys map[string][]KeyInfo
+
+func (b biggestKeys) add(k KeyInfo) {
+	b[k.Type] = insertKey(b[k.Type], k, maxKeysPerType, func(k KeyInfo) int { return k.Size })
+}
+
+func (b biggestKeys) finish(s *Stats) {
+	s.BiggestKeys = b
+}
+
+// SizeBucket counts the keys whose size is at least MinSize and less than
+// twice MinSize, or zero if MinSize is zero.
+type SizeBucket struct {
+	MinSize int
+	Keys    int
+	Bytes   int
+}
+
+// sizeHistogram counts the keys per power of two of their size.
+type sizeHistogram []SizeBucket
+
+func (h *sizeHistogram) add(k KeyInfo) {
+	i := bits.Len(uint(k.Size))
+	for len(*h) <= i {
+		var min int
+		if n := len(*h); n > 0 {
+			min = 1 << uint(n-1)
+		}
+		*h = append(*h, SizeBucket{MinSize: min})
+	}
+
+	(*h)[i].Keys++
+	(*h)[i].Bytes += k.Size
+}
+
+func (h *sizeHistogram) finish(s *Stats) {
+	s.SizeHistogram = *h
+}
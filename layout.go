@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ajstarks/svgo"
+)
+
+// box is a rectangle of the canvas.
+type box struct {
+	x, y int
+	w, h int
+}
+
+// defaultPanelHeight is the height of the panels which don't declare one,
+// like the tables and the bar charts.
+const defaultPanelHeight = 320
+
+// panel is a part of the SVG report.
+//
+// A panel spans a number of columns of the grid and declares its preferred
+// height for the width it is given; the layout flows the panels into rows.
+type panel struct {
+	name    string
+	title   string
+	columns int             // the number of columns spanned, 0 for all of them
+	height  func(w int) int // the preferred height for the width w, nil for defaultPanelHeight
+	render  func(canvas *svg.SVG, s *Stats, b box)
+
+	// describe returns the text alternative of the panel, for the screen
+	// readers. It is nil if the title is enough.
+	describe func(s *Stats) string
+
+	// available reports whether the stats have the data of the panel. It is
+	// nil if the panel is always available.
+	available func(s *Stats) bool
+}
+
+func (p panel) isAvailable(s *Stats) bool {
+	return p.available == nil || p.available(s)
+}
+
+func (p panel) preferredHeight(w int) int {
+	if p.height == nil {
+		return defaultPanelHeight
+	}
+	return p.height(w)
+}
+
+func (p panel) description(s *Stats) string {
+	if p.describe == nil {
+		return p.title
+	}
+	return p.describe(s)
+}
+
+// fixedHeight returns a height function which always returns h.
+func fixedHeight(h int) func(w int) int {
+	return func(int) int { return h }
+}
+
+// placedPanel is a panel with its position in the canvas.
+type placedPanel struct {
+	panel
+	box
+}
+
+// minColumnWidth is the width of the narrowest column of the grid in which
+// the panels can still be drawn.
+const minColumnWidth = 200
+
+// checkLayout returns an error if a canvas of the given width split into
+// columns has columns narrower than minColumnWidth.
+func checkLayout(width, columns int) error {
+	if columns < 1 {
+		return fmt.Errorf("invalid number of columns %d", columns)
+	}
+	if w := newGridLayout(width, columns).columnWidth(); w < minColumnWidth {
+		return fmt.Errorf("invalid width %d for %d columns, the columns are %d pixels wide instead of at least %d", width, columns, w, minColumnWidth)
+	}
+	return nil
+}
+
+// gridLayout flows panels from left to right in rows of a grid.
+type gridLayout struct {
+	width   int // width of the canvas
+	columns int
+	margin  int // around the grid
+	hgap    int // between two columns
+	vgap    int // between two rows
+}
+
+func newGridLayout(width, columns int) gridLayout {
+	if columns < 1 {
+		columns = 1
+	}
+
+	return gridLayout{
+		width:   width,
+		columns: columns,
+		margin:  left,
+		hgap:    columnSpacing,
+		vgap:    rowMargin,
+	}
+}
+
+func (l gridLayout) columnWidth() int {
+	return (l.width - l.margin*2 - l.hgap*(l.columns-1)) / l.columns
+}
+
+// spanWidth returns the width of a panel spanning n columns.
+func (l gridLayout) spanWidth(n int) int {
+	if n <= 0 || n > l.columns {
+		n = l.columns
+	}
+	return n*l.columnWidth() + (n-1)*l.hgap
+}
+
+// place returns the position of each panel and the height of the canvas.
+//
+// A panel which doesn't fit in the columns left in the current row starts a
+// new row. All the panels of a row are stretched to the height of the tallest.
+func (l gridLayout) place(panels []panel) ([]placedPanel, int) {
+	var (
+		res       []placedPanel
+		rowStart  int
+		column    int
+		y         = l.margin
+		rowHeight int
+	)
+
+	endRow := func() {
+		for i := rowStart; i < len(res); i++ {
+			res[i].h = rowHeight
+		}
+		y += rowHeight + l.vgap
+
+		rowStart, column, rowHeight = len(res), 0, 0
+	}
+
+	for _, p := range panels {
+		span := p.columns
+		if span <= 0 || span > l.columns {
+			span = l.columns
+		}
+		if column+span > l.columns {
+			endRow()
+		}
+
+		w := l.spanWidth(span)
+		h := p.preferredHeight(w)
+		x := l.margin + column*(l.columnWidth()+l.hgap)
+
+		res = append(res, placedPanel{p, box{x, y, w, h}})
+
+		column += span
+		if h > rowHeight {
+			rowHeight = h
+		}
+	}
+	if len(res) > rowStart {
+		endRow()
+	}
+
+	return res, y - l.vgap + l.margin
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/vrischmann/rdbtools"
@@ -15,241 +17,625 @@ import (
 
 var (
 	flSVGOutput  string
+	flCharts     string
+	flSVGWidth   int
+	flSVGColumns int
+	flDPI        float64
+	flTheme      string
 	flListenAddr string
 
-	flDebugStats  string
-	flDebugRender string
+	flTopKeys         int
+	flPrefixDelimiter string
+	flPrefixDepth     int
+	flCollectors      string
 
-	keysCh = make(chan rdbtools.KeyObject)
+	flEncodingThresholds string
+	flEncodingMargin     float64
 
-	dbCh                = make(chan int)
-	stringObjectCh      = make(chan rdbtools.StringObject)
-	listMetadataCh      = make(chan rdbtools.ListMetadata)
-	listDataCh          = make(chan interface{})
-	setMetadataCh       = make(chan rdbtools.SetMetadata)
-	setDataCh           = make(chan interface{})
-	hashMetadataCh      = make(chan rdbtools.HashMetadata)
-	hashDataCh          = make(chan rdbtools.HashEntry)
-	sortedSetMetadataCh = make(chan rdbtools.SortedSetMetadata)
-	sortedSetEntriesCh  = make(chan rdbtools.SortedSetEntry)
+	flCompressionSample float64
+	flCompressionBudget time.Duration
 
-	stats Stats
+	flDuplicatesPreview int
+
+	flText     bool
+	flTextTop  int
+	flTextBars bool
+	flNoColor  bool
+	flTUI      bool
+
+	flPromTextfile string
+	flPromPrefixes int
+
+	flWatch         string
+	flWatchInterval time.Duration
+	flHistory       int
 
-	wg sync.WaitGroup
+	flMaxJobs       int
+	flMaxReports    int
+	flMaxUploadSize int64
+
+	flStatsOutput string
+	flDebugRender string
+
+	stats Stats
 )
 
+// init registers the flags of the deprecated command line without subcommands.
 func init() {
-	flag.StringVar(&flSVGOutput, "o", "", "The SVG output file")
-	flag.StringVar(&flListenAddr, "l", "", "The listen address of the web server")
+	addAnalysisFlags(flag.CommandLine)
+	addOutputFlags(flag.CommandLine)
+	addTextFlags(flag.CommandLine)
+	addPromFlags(flag.CommandLine)
+	addServerFlags(flag.CommandLine, "")
 
-	flag.StringVar(&flDebugStats, "debug-stats", "", "DEBUG: the stats output file")
+	flag.StringVar(&flStatsOutput, "debug-stats", "", "DEBUG: the stats output file")
 	flag.StringVar(&flDebugRender, "debug-render", "", "DEBUG: only render the visualization of the stats from the provided file")
+
+	flag.Usage = printUsage
 }
 
-func processDBs() {
-	defer wg.Done()
-	for range dbCh {
-		stats.Database.Count++
-	}
+// analyzer consumes the objects sent by the RDB parser and fills the stats.
+//
+// Everything is received by a single goroutine: the parser sends the objects in
+// file order, so the data of a list, set, hash or sorted set always comes right
+// after its metadata and can be attributed to the right key.
+type analyzer struct {
+	stats *Stats
+	now   time.Time
+
+	db       int
+	current  *KeyInfo
+	encoding string // of the next key, sent by the native parser before it
+
+	collectors []collector
+	values     []valueCollector // the collectors which also read the values
+	entries    []entryCollector // the collectors which also read the fields of the hashes
 }
 
-func processKeys() {
-	defer wg.Done()
-	for key := range keysCh {
-		stats.Keys.Count++
-		now := time.Now()
+func newAnalyzer(s *Stats) *analyzer {
+	now := time.Now()
 
-		switch {
-		case key.ExpiryTime.IsZero():
-			break
-		case key.ExpiryTime.After(now):
-			stats.Keys.Expiring++
-		case key.ExpiryTime.Before(now):
-			stats.Keys.Expired++
+	a := &analyzer{
+		stats:      s,
+		now:        now,
+		collectors: newCollectors(now),
+	}
+	for _, c := range a.collectors {
+		if vc, ok := c.(valueCollector); ok {
+			a.values = append(a.values, vc)
+		}
+		if ec, ok := c.(entryCollector); ok {
+			a.entries = append(a.entries, ec)
 		}
 	}
+
+	return a
 }
 
-func processStrings() {
-	defer wg.Done()
-	for obj := range stringObjectCh {
-		keysCh <- obj.Key
+// startKey flushes the key currently being processed and starts a new one.
+func (a *analyzer) startKey(typ string, key rdbtools.KeyObject) {
+	a.flushKey()
+
+	a.stats.Keys.Count++
 
-		stats.Strings.Count++
+	db := a.dbStats()
+	db.Keys++
 
-		// TODO(vincent): this will fail if it's the wrong type, fix it !
-		stringLength := len(obj.Value.([]uint8))
-		stats.Strings.TotalByteSize += stringLength
+	switch {
+	case key.ExpiryTime.IsZero():
+		break
+	case key.ExpiryTime.After(a.now):
+		a.stats.Keys.Expiring++
+		db.Expiring++
+	case key.ExpiryTime.Before(a.now):
+		a.stats.Keys.Expired++
+		db.Expired++
 	}
+
+	a.current = &KeyInfo{
+		DB:         a.db,
+		Name:       dataToString(key.Key),
+		Type:       typ,
+		ExpiryTime: key.ExpiryTime,
+		Encoding:   a.encoding,
+	}
+	a.encoding = ""
 }
 
-func processListMetadata() {
-	defer wg.Done()
-	for obj := range listMetadataCh {
-		keysCh <- obj.Key
+// flushKey records the key currently being processed, if any.
+func (a *analyzer) flushKey() {
+	if a.current == nil {
+		return
+	}
 
-		stats.Lists.Count++
+	a.dbStats().Bytes += a.current.Size
+	for _, c := range a.collectors {
+		c.add(*a.current)
 	}
+
+	a.current = nil
 }
 
-func processListData() {
-	defer wg.Done()
-	for obj := range listDataCh {
-		stats.Lists.TotalByteSize += len(obj.([]byte))
+// addElement accounts an element of the key currently being processed.
+func (a *analyzer) addElement(size int) {
+	if a.current == nil {
+		return
 	}
+
+	a.current.Length++
+	a.current.Size += size
+	a.updateMaxElement(size)
 }
 
-func processSetMetadata() {
-	defer wg.Done()
-	for obj := range setMetadataCh {
-		keysCh <- obj.Key
-		stats.Sets.Count++
+// addEntry accounts a field and its value of the hash currently being processed.
+func (a *analyzer) addEntry(field, value int) {
+	if a.current == nil {
+		return
 	}
+
+	a.current.Length++
+	a.current.Size += field + value
+	a.updateMaxElement(field)
+	a.updateMaxElement(value)
 }
 
-func processSetData() {
-	defer wg.Done()
-	for obj := range setDataCh {
-		stats.Sets.TotalByteSize += len(obj.([]byte))
+func (a *analyzer) updateMaxElement(size int) {
+	if size > a.current.MaxElement {
+		a.current.MaxElement = size
 	}
 }
 
-func processHashMetadata() {
-	defer wg.Done()
-	for obj := range hashMetadataCh {
-		keysCh <- obj.Key
-		stats.Hashes.Count++
+// finish flushes the last key and stores the collected results in the stats.
+func (a *analyzer) finish() {
+	a.flushKey()
+
+	for _, c := range a.collectors {
+		c.finish(a.stats)
 	}
 }
 
-func processHashData() {
-	defer wg.Done()
-	for entry := range hashDataCh {
-		stats.Hashes.TotalByteSize += len(entry.Key.([]uint8))
-		stats.Hashes.TotalByteSize += len(entry.Value.([]uint8))
+// dbStats returns the stats of the current database.
+func (a *analyzer) dbStats() *DBStats {
+	dbs := a.stats.Database.Databases
+	if len(dbs) == 0 || dbs[len(dbs)-1].DB != a.db {
+		a.stats.Database.Databases = append(dbs, DBStats{DB: a.db})
 	}
+
+	return &a.stats.Database.Databases[len(a.stats.Database.Databases)-1]
+}
+
+func (a *analyzer) processDB(db int) {
+	a.flushKey()
+
+	a.db = db
+	a.stats.Database.Count++
+	a.dbStats()
 }
 
-func processSortedSetMetadata() {
-	defer wg.Done()
-	for obj := range sortedSetMetadataCh {
-		keysCh <- obj.Key
-		stats.SortedSets.Count++
+func (a *analyzer) processString(obj rdbtools.StringObject) {
+	a.startKey(stringType, obj.Key)
+
+	stringLength := dataLen(obj.Value)
+
+	a.stats.Strings.Count++
+	a.stats.Strings.TotalByteSize += stringLength
+
+	a.addElement(stringLength)
+	a.addValue(obj.Value)
+}
+
+// addValue passes the value of the string or an element of the collection
+// currently being processed to the value collectors.
+func (a *analyzer) addValue(obj interface{}) {
+	if len(a.values) == 0 || a.current == nil {
+		return
+	}
+
+	v := dataBytes(obj)
+	for _, c := range a.values {
+		c.addValue(*a.current, v)
 	}
 }
 
-func processSortedSetEntries() {
-	defer wg.Done()
-	for range sortedSetEntriesCh {
+// addHashEntry passes a field and its value of the hash currently being
+// processed to the entry collectors.
+func (a *analyzer) addHashEntry(entry rdbtools.HashEntry) {
+	if len(a.entries) == 0 || a.current == nil {
+		return
+	}
+
+	field, value := dataBytes(entry.Key), dataBytes(entry.Value)
+	for _, c := range a.entries {
+		c.addEntry(*a.current, field, value)
 	}
 }
 
-func printUsageAndAbort() {
-	fmt.Printf("Usage: rdbanalyzer (-o <output svg file>|-l <listen address>) <rdb file>\n\n")
-	fmt.Println("There's two running modes:")
-	fmt.Println(" - run and then output a SVG file on disk (with -o)")
-	fmt.Println(" - run and then launch a web server which will serve a unique page with the SVG graph (with -l)")
+func (a *analyzer) processListMetadata(obj rdbtools.ListMetadata) {
+	a.startKey(listType, obj.Key)
+	a.stats.Lists.Count++
+}
+
+func (a *analyzer) processListData(obj interface{}) {
+	n := dataLen(obj)
+	a.stats.Lists.TotalByteSize += n
+	a.addElement(n)
+	a.addValue(obj)
+}
+
+func (a *analyzer) processSetMetadata(obj rdbtools.SetMetadata) {
+	a.startKey(setType, obj.Key)
+	a.stats.Sets.Count++
+	a.current.Integers = true
+}
+
+func (a *analyzer) processSetData(obj interface{}) {
+	n := dataLen(obj)
+	a.stats.Sets.TotalByteSize += n
+	a.addElement(n)
+	a.addValue(obj)
+
+	if a.current != nil && stringEncoding([]byte(dataToString(obj))) != intEncoding {
+		a.current.Integers = false
+	}
+}
+
+func (a *analyzer) processHashMetadata(obj rdbtools.HashMetadata) {
+	a.startKey(hashType, obj.Key)
+	a.stats.Hashes.Count++
+}
+
+func (a *analyzer) processHashData(entry rdbtools.HashEntry) {
+	field, value := dataLen(entry.Key), dataLen(entry.Value)
+	a.stats.Hashes.TotalByteSize += field + value
+	a.addEntry(field, value)
+	a.addValue(entry.Value)
+	a.addHashEntry(entry)
+}
+
+func (a *analyzer) processSortedSetMetadata(obj rdbtools.SortedSetMetadata) {
+	a.startKey(sortedSetType, obj.Key)
+	a.stats.SortedSets.Count++
+}
+
+func (a *analyzer) processSortedSetEntry(entry rdbtools.SortedSetEntry) {
+	n := dataLen(entry.Value)
+	a.stats.SortedSets.TotalByteSize += n
+	a.addElement(n)
+	a.addValue(entry.Value)
+}
+
+func (a *analyzer) processStream(obj streamObject) {
+	a.startKey(streamType, obj.Key)
+
+	st := &a.stats.Streams
+	st.Count++
+	st.TotalByteSize += obj.Bytes
+	st.Entries += obj.Entries
+	st.Groups += obj.Groups
+	st.Consumers += obj.Consumers
+	st.PendingEntries += obj.PendingEntries
+
+	a.current.Length = obj.Entries
+	a.current.Size = obj.Bytes
+}
+
+func (a *analyzer) processModule(obj moduleObject) {
+	a.startKey(moduleType, obj.Key)
+
+	m := &a.stats.Modules
+	m.Count++
+	m.TotalByteSize += obj.Bytes
+	if m.Types == nil {
+		m.Types = make(map[string]int)
+	}
+	m.Types[obj.Module]++
+
+	a.current.Length = 1
+	a.current.Size = obj.Bytes
+}
 
+func (a *analyzer) processEncoding(encoding string) {
+	a.encoding = encoding
+}
+
+func (a *analyzer) processFunction(obj functionObject) {
+	a.stats.Functions.Libraries++
+	a.stats.Functions.TotalByteSize += obj.Bytes
+}
+
+// run receives every object sent by the parser until all channels are closed
+// or stop is closed.
+func (a *analyzer) run(ctx parserContext, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	open := 14
+	for open > 0 {
+		select {
+		case <-stop:
+			return
+		case v, ok := <-ctx.DbCh:
+			if !ok {
+				ctx.DbCh = nil
+				open--
+				break
+			}
+			a.processDB(v)
+		case v, ok := <-ctx.StringObjectCh:
+			if !ok {
+				ctx.StringObjectCh = nil
+				open--
+				break
+			}
+			a.processString(v)
+		case v, ok := <-ctx.ListMetadataCh:
+			if !ok {
+				ctx.ListMetadataCh = nil
+				open--
+				break
+			}
+			a.processListMetadata(v)
+		case v, ok := <-ctx.ListDataCh:
+			if !ok {
+				ctx.ListDataCh = nil
+				open--
+				break
+			}
+			a.processListData(v)
+		case v, ok := <-ctx.SetMetadataCh:
+			if !ok {
+				ctx.SetMetadataCh = nil
+				open--
+				break
+			}
+			a.processSetMetadata(v)
+		case v, ok := <-ctx.SetDataCh:
+			if !ok {
+				ctx.SetDataCh = nil
+				open--
+				break
+			}
+			a.processSetData(v)
+		case v, ok := <-ctx.HashMetadataCh:
+			if !ok {
+				ctx.HashMetadataCh = nil
+				open--
+				break
+			}
+			a.processHashMetadata(v)
+		case v, ok := <-ctx.HashDataCh:
+			if !ok {
+				ctx.HashDataCh = nil
+				open--
+				break
+			}
+			a.processHashData(v)
+		case v, ok := <-ctx.SortedSetMetadataCh:
+			if !ok {
+				ctx.SortedSetMetadataCh = nil
+				open--
+				break
+			}
+			a.processSortedSetMetadata(v)
+		case v, ok := <-ctx.SortedSetEntriesCh:
+			if !ok {
+				ctx.SortedSetEntriesCh = nil
+				open--
+				break
+			}
+			a.processSortedSetEntry(v)
+		case v, ok := <-ctx.StreamCh:
+			if !ok {
+				ctx.StreamCh = nil
+				open--
+				break
+			}
+			a.processStream(v)
+		case v, ok := <-ctx.ModuleCh:
+			if !ok {
+				ctx.ModuleCh = nil
+				open--
+				break
+			}
+			a.processModule(v)
+		case v, ok := <-ctx.FunctionCh:
+			if !ok {
+				ctx.FunctionCh = nil
+				open--
+				break
+			}
+			a.processFunction(v)
+		case v, ok := <-ctx.EncodingCh:
+			if !ok {
+				ctx.EncodingCh = nil
+				open--
+				break
+			}
+			a.processEncoding(v)
+		}
+	}
+
+	a.finish()
+}
+
+func printUsageAndAbort() {
+	printUsage()
 	os.Exit(1)
 }
 
-func parse(filename string) error {
-	file := flag.Arg(0)
-	f, err := os.Open(file)
+// analyzeRDB parses the RDB data read from r and fills s.
+func analyzeRDB(r io.Reader, s *Stats) error {
+	ctx := newParserContext()
+
+	var (
+		stop = make(chan struct{})
+		done = make(chan struct{})
+	)
+	start := time.Now()
+	go newAnalyzer(s).run(ctx, stop, done)
+
+	r, err := decompress(r)
+	if err != nil {
+		close(stop)
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, rdbHeaderPeekSize)
+	s.RDB = readRDBInfo(br)
+
+	if s.RDB != nil && s.RDB.Version > rdbtoolsMaxVersion {
+		err = newRDBParser(ctx, s.RDB).parse(br)
+	} else {
+		err = rdbtools.NewParser(ctx.ParserContext).Parse(br)
+		if err == nil {
+			ctx.closeExtended()
+		}
+	}
+	if err != nil {
+		close(stop)
+		return fmt.Errorf("unable to parse RDB file. err=%v", err)
+	}
+
+	<-done
+
+	s.ParseDuration = time.Now().Sub(start)
+
+	return nil
+}
+
+// decompress returns a reader of the decompressed data if r is gzip or bzip2
+// compressed, r itself otherwise.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to read RDB file. err=%v", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read gzip data. err=%v", err)
+		}
+		return gr, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	default:
+		return br, nil
+	}
+}
+
+func parse(filename string, s *Stats) error {
+	f, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("unable to open file '%s'. err=%v", file, err)
-	}
-
-	ctx := rdbtools.ParserContext{
-		DbCh:                dbCh,
-		StringObjectCh:      stringObjectCh,
-		ListMetadataCh:      listMetadataCh,
-		ListDataCh:          listDataCh,
-		SetMetadataCh:       setMetadataCh,
-		SetDataCh:           setDataCh,
-		HashMetadataCh:      hashMetadataCh,
-		HashDataCh:          hashDataCh,
-		SortedSetMetadataCh: sortedSetMetadataCh,
-		SortedSetEntriesCh:  sortedSetEntriesCh,
-	}
-
-	wg.Add(10)
-
-	go processDBs()
-	go processKeys()
-	go processStrings()
-	go processListMetadata()
-	go processListData()
-	go processSetMetadata()
-	go processSetData()
-	go processHashMetadata()
-	go processHashData()
-	go processSortedSetMetadata()
-	go processSortedSetEntries()
+		return fmt.Errorf("unable to open file '%s'. err=%v", filename, err)
+	}
+	defer f.Close()
 
 	now := time.Now()
 
 	// Parsing
 
-	fmt.Printf("parsing RDB file %s\n", file)
+	fmt.Fprintf(os.Stderr, "parsing RDB file %s\n", filename)
 
-	parser := rdbtools.NewParser(ctx)
-	if err := parser.Parse(f); err != nil {
-		return fmt.Errorf("unable to parse RDB file. err=%v", err)
+	if err := analyzeRDB(f, s); err != nil {
+		return err
 	}
 
-	wg.Wait()
-
-	fmt.Printf("parsing time: %s\n", time.Now().Sub(now))
+	fmt.Fprintf(os.Stderr, "parsing time: %s\n", time.Now().Sub(now))
 
 	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			os.Exit(runCommand(cmd, os.Args[2:]))
+		}
+	}
+
+	if len(os.Args) < 2 {
+		printUsageAndAbort()
+	}
+
+	legacyMain()
+}
+
+// legacyMain runs the deprecated command line without subcommands, where the
+// behavior depends on the combination of -o, -l, -debug-stats and -debug-render.
+func legacyMain() {
 	flag.Parse()
 
-	requireSVG := (flDebugStats != "" && flDebugRender == "") || (flDebugStats == "")
+	fmt.Fprintln(os.Stderr, "warning: running rdbanalyzer without a command is deprecated, see 'rdbanalyzer help'")
+
+	requireSVG := (flStatsOutput != "" && flDebugRender == "") || (flStatsOutput == "")
 	hasSVG := flSVGOutput != "" || flListenAddr != ""
+	hasOutput := hasSVG || flText || flTUI || flPromTextfile != ""
+	serveOnly := flListenAddr != "" && flSVGOutput == "" && flStatsOutput == ""
+
+	if err := parseEncodingFlags(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	switch {
+	case flWatch != "":
+		if flListenAddr == "" {
+			fmt.Println("With -watch you need to also pass the -l option")
+			os.Exit(1)
+		}
+		if flHistory < 1 {
+			fmt.Println("-history must keep at least one snapshot")
+			os.Exit(1)
+		}
+
+		if err := watchAndServe(flWatch); err != nil {
+			log.Fatalf("unable to listen on %s. err=%v", flListenAddr, err)
+		}
+
+		return
+
 	case flDebugRender != "":
 		if !hasSVG {
 			fmt.Println("With --debug-render you need to also pass the -o or -l option")
 			os.Exit(1)
 		}
 
-		data, err := ioutil.ReadFile(flDebugRender)
+		s, err := readStats(flDebugRender)
 		if err != nil {
-			log.Fatalf("unable to read stats file '%s'. err=%v", flDebugRender, err)
+			log.Fatal(err)
 		}
 
-		if err := json.Unmarshal(data, &stats); err != nil {
-			log.Fatalf("unable to unmarshal stats. err=%v", err)
-		}
-
-		if err := renderStats(); err != nil {
+		if err := renderStats(s); err != nil {
 			log.Fatalf("unable to render stats. err=%v", err)
 		}
 
 		return
 
-	case (requireSVG && flag.NArg() < 1) || (requireSVG && !hasSVG):
+	case (requireSVG && flag.NArg() < 1 && !serveOnly) || (requireSVG && !hasOutput):
 		printUsageAndAbort()
+
+	case flag.NArg() < 1:
+		// Web server without a RDB file: only the upload form is useful.
+		if err := renderStats(nil); err != nil {
+			log.Fatalf("unable to render stats. err=%v", err)
+		}
+
+		return
 	}
 
-	if err := parse(flag.Arg(0)); err != nil {
+	if err := parse(flag.Arg(0), &stats); err != nil {
 		log.Fatal(err)
 	}
 
-	if flDebugStats != "" {
-		if err := writeStats(flDebugStats); err != nil {
-			log.Fatalf("unable to write stats. err=%v", err)
-		}
+	if err := writeReports(&stats); err != nil {
+		log.Fatal(err)
 	}
 
 	// Rendering
-	if flDebugStats == "" {
-		if err := renderStats(); err != nil {
+	if flStatsOutput == "" {
+		if err := renderStats(&stats); err != nil {
 			log.Fatalf("unable to render stats. err=%v", err)
 		}
 	}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// header writes the HELP and TYPE lines of a metric family.
+func (m *metricsWriter) header(name, help string) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// sample writes a sample. labels is a list of label names and values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	if m.err != nil {
+		return
+	}
+
+	var buf strings.Builder
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(labels[i])
+			buf.WriteString(`="`)
+			buf.WriteString(escapeLabelValue(labels[i+1]))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	buf.WriteByte('\n')
+
+	_, m.err = m.w.WriteString(buf.String())
+}
+
+func (m *metricsWriter) gauge(name, help string, value float64) {
+	m.header(name, help)
+	m.sample(name, value)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+// writeMetrics writes the stats as Prometheus metrics.
+//
+// To keep the cardinality bounded, only the flPromPrefixes biggest top level
+// prefixes are exported, the remaining ones are accounted in the otherNamespace
+// prefix. A real prefix with that name is accounted there too, so that it
+// doesn't export the same series twice.
+func writeMetrics(w io.Writer, s *Stats) error {
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+
+	m.gauge("rdb_databases", "Number of databases in the RDB file.", float64(s.Database.Count))
+	m.gauge("rdb_keys", "Number of keys in the RDB file.", float64(s.Keys.Count))
+	m.gauge("rdb_keys_expiring", "Number of keys with an expiry time in the future.", float64(s.Keys.Expiring))
+	m.gauge("rdb_keys_expired", "Number of keys with an expiry time in the past.", float64(s.Keys.Expired))
+	m.gauge("rdb_bytes", "Byte size of all the values.", float64(s.TotalByteSize()))
+	m.gauge("rdb_parse_duration_seconds", "Time taken to parse the RDB file.", s.ParseDuration.Seconds())
+
+	types := []struct {
+		name  string
+		count int
+		bytes int
+	}{
+		{stringType, s.Strings.Count, s.Strings.TotalByteSize},
+		{listType, s.Lists.Count, s.Lists.TotalByteSize},
+		{setType, s.Sets.Count, s.Sets.TotalByteSize},
+		{hashType, s.Hashes.Count, s.Hashes.TotalByteSize},
+		{sortedSetType, s.SortedSets.Count, s.SortedSets.TotalByteSize},
+		{streamType, s.Streams.Count, s.Streams.TotalByteSize},
+		{moduleType, s.Modules.Count, s.Modules.TotalByteSize},
+	}
+
+	m.header("rdb_type_keys", "Number of keys per type.")
+	for _, t := range types {
+		m.sample("rdb_type_keys", float64(t.count), "type", t.name)
+	}
+	m.header("rdb_type_bytes", "Byte size of the values per type.")
+	for _, t := range types {
+		m.sample("rdb_type_bytes", float64(t.bytes), "type", t.name)
+	}
+
+	if e := s.Encodings; e != nil {
+		m.header("rdb_encoding_keys", "Number of keys per type and encoding.")
+		for _, te := range e.Encodings {
+			m.sample("rdb_encoding_keys", float64(te.Keys), "type", te.Type, "encoding", te.Encoding)
+		}
+		m.header("rdb_encoding_bytes", "Byte size of the values per type and encoding.")
+		for _, te := range e.Encodings {
+			m.sample("rdb_encoding_bytes", float64(te.Bytes), "type", te.Type, "encoding", te.Encoding)
+		}
+		m.header("rdb_near_encoding_threshold_keys", "Number of keys in the full encoding which would be compact with the threshold raised by the margin.")
+		for _, n := range e.NearThresholds {
+			m.sample("rdb_near_encoding_threshold_keys", float64(n.Keys), "setting", n.Setting)
+		}
+	}
+
+	dbs := s.Database.Databases
+	m.header("rdb_db_keys", "Number of keys per database.")
+	for _, db := range dbs {
+		m.sample("rdb_db_keys", float64(db.Keys), "db", strconv.Itoa(db.DB))
+	}
+	m.header("rdb_db_bytes", "Byte size of the values per database.")
+	for _, db := range dbs {
+		m.sample("rdb_db_bytes", float64(db.Bytes), "db", strconv.Itoa(db.DB))
+	}
+	m.header("rdb_db_keys_expiring", "Number of keys with an expiry time in the future per database.")
+	for _, db := range dbs {
+		m.sample("rdb_db_keys_expiring", float64(db.Expiring), "db", strconv.Itoa(db.DB))
+	}
+	m.header("rdb_db_keys_expired", "Number of keys with an expiry time in the past per database.")
+	for _, db := range dbs {
+		m.sample("rdb_db_keys_expired", float64(db.Expired), "db", strconv.Itoa(db.DB))
+	}
+
+	if s.Prefixes != nil {
+		type prefix struct {
+			name  string
+			keys  int
+			bytes int
+		}
+
+		var (
+			prefixes []prefix
+			other    = prefix{name: otherNamespace, keys: s.Prefixes.Keys, bytes: s.Prefixes.Bytes}
+		)
+		for _, c := range s.Prefixes.SortedChildren() {
+			if len(prefixes) >= flPromPrefixes {
+				break
+			}
+			if c.Name == otherNamespace {
+				continue
+			}
+			prefixes = append(prefixes, prefix{c.Name, c.Keys, c.Bytes})
+
+			other.keys -= c.Keys
+			other.bytes -= c.Bytes
+		}
+		prefixes = append(prefixes, other)
+
+		m.header("rdb_prefix_keys", "Number of keys per top level prefix.")
+		for _, p := range prefixes {
+			m.sample("rdb_prefix_keys", float64(p.keys), "prefix", p.name)
+		}
+		m.header("rdb_prefix_bytes", "Byte size of the values per top level prefix.")
+		for _, p := range prefixes {
+			m.sample("rdb_prefix_bytes", float64(p.bytes), "prefix", p.name)
+		}
+	}
+
+	if m.err != nil {
+		return m.err
+	}
+
+	return m.w.Flush()
+}
+
+// writePromTextfile writes the metrics to filename. The file is written
+// atomically so that the node_exporter never reads a partial file.
+func writePromTextfile(filename string, s *Stats) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return fmt.Errorf("unable to create file. err=%v", err)
+	}
+
+	if err := writeMetrics(f, s); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write metrics to file. err=%v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write metrics to file. err=%v", err)
+	}
+
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to change the mode of file '%s'. err=%v", f.Name(), err)
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	st, _ := s.currentStats()
+	if st == nil {
+		noStats(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, st)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sort"
+)
+
+const (
+	// maxNoTTLNamespaces is the number of namespaces tracked, see boundedKey.
+	maxNoTTLNamespaces = 10000
+
+	// maxNoTTLReported is the number of namespaces stored in the stats.
+	maxNoTTLReported = 100
+
+	noNamespace = "(none)"
+)
+
+// NamespaceTTL counts the keys of a namespace with and without TTL.
+type NamespaceTTL struct {
+	Name       string
+	Keys       int
+	Bytes      int
+	NoTTLKeys  int
+	NoTTLBytes int
+}
+
+// Leaking reports whether the majority of the keys of the namespace never expire.
+func (n NamespaceTTL) Leaking() bool {
+	return n.NoTTLKeys*2 > n.Keys
+}
+
+// NoTTLStats are the stats of the keys without TTL.
+type NoTTLStats struct {
+	Keys       int
+	Bytes      int
+	Namespaces []NamespaceTTL // sorted by bytes without TTL, biggest first
+}
+
+// noTTLCollector accounts the keys with and without TTL per namespace.
+//
+// The namespace of a key is its prefix pattern, like user:* for user:1:name.
+type noTTLCollector struct {
+	delimiter  string
+	depth      int
+	namespaces map[string]*NamespaceTTL
+	stats      NoTTLStats
+}
+
+func newNoTTLCollector(delimiter string, depth int) *noTTLCollector {
+	return &noTTLCollector{
+		delimiter:  delimiter,
+		depth:      depth,
+		namespaces: make(map[string]*NamespaceTTL),
+	}
+}
+
+func (c *noTTLCollector) add(k KeyInfo) {
+	name := boundedKey(c.namespaces, keyNamespace(k.Name, c.delimiter, c.depth), maxNoTTLNamespaces)
+
+	ns, ok := c.namespaces[name]
+	if !ok {
+		ns = &NamespaceTTL{Name: name}
+		c.namespaces[name] = ns
+	}
+
+	ns.Keys++
+	ns.Bytes += k.Size
+
+	if k.ExpiryTime.IsZero() {
+		ns.NoTTLKeys++
+		ns.NoTTLBytes += k.Size
+
+		c.stats.Keys++
+		c.stats.Bytes += k.Size
+	}
+}
+
+func (c *noTTLCollector) finish(s *Stats) {
+	res := c.stats
+	for _, ns := range c.namespaces {
+		res.Namespaces = append(res.Namespaces, *ns)
+	}
+
+	sort.Slice(res.Namespaces, func(i, j int) bool {
+		a, b := res.Namespaces[i], res.Namespaces[j]
+		if a.NoTTLBytes != b.NoTTLBytes {
+			return a.NoTTLBytes > b.NoTTLBytes
+		}
+		return a.Name < b.Name
+	})
+	if len(res.Namespaces) > maxNoTTLReported {
+		res.Namespaces = res.Namespaces[:maxNoTTLReported]
+	}
+
+	s.NoTTL = &res
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxPrefixNodes bounds the size of the prefix tree. Once reached, new
+	// prefixes are accounted in a wildcard child of their parent.
+	maxPrefixNodes = 50000
+
+	// maxPrefixTopKeys is the number of biggest keys kept in each node.
+	maxPrefixTopKeys = 10
+
+	wildcardPrefix = "*"
+
+	// otherNamespace accounts together the prefixes left out of a bounded
+	// list of prefixes.
+	otherNamespace = "(other)"
+)
+
+// PrefixNode is a node of the tree of key prefixes.
+//
+// All the stats include the keys below the node.
+type PrefixNode struct {
+	Name      string
+	Keys      int
+	Bytes     int
+	TypeBytes map[string]int
+	TTL       TTLDistribution
+	TopKeys   []KeyInfo
+	Children  map[string]*PrefixNode `json:",omitempty"`
+}
+
+// add accounts the key k in the node.
+func (n *PrefixNode) add(k KeyInfo, now time.Time) {
+	n.Keys++
+	n.Bytes += k.Size
+
+	if n.TypeBytes == nil {
+		n.TypeBytes = make(map[string]int)
+	}
+	n.TypeBytes[k.Type] += k.Size
+
+	n.TTL.add(k.ExpiryTime, now)
+
+	// Keep the biggest keys sorted, from the biggest to the smallest.
+	i := sort.Search(len(n.TopKeys), func(i int) bool { return n.TopKeys[i].Size < k.Size })
+	if i >= maxPrefixTopKeys {
+		return
+	}
+	if len(n.TopKeys) < maxPrefixTopKeys {
+		n.TopKeys = append(n.TopKeys, KeyInfo{})
+	}
+	copy(n.TopKeys[i+1:], n.TopKeys[i:])
+	n.TopKeys[i] = k
+}
+
+// child returns the child named name, creating it if necessary.
+func (n *PrefixNode) child(name string) *PrefixNode {
+	if n.Children == nil {
+		n.Children = make(map[string]*PrefixNode)
+	}
+
+	c, ok := n.Children[name]
+	if !ok {
+		c = &PrefixNode{Name: name}
+		n.Children[name] = c
+	}
+
+	return c
+}
+
+// Lookup returns the node at the given path or nil if it doesn't exist.
+func (n *PrefixNode) Lookup(path []string) *PrefixNode {
+	node := n
+	for _, p := range path {
+		if node == nil || node.Children == nil {
+			return nil
+		}
+		node = node.Children[p]
+	}
+
+	return node
+}
+
+// Depth returns the number of segments of the longest prefixes below n.
+func (n *PrefixNode) Depth() int {
+	res := 0
+	for _, c := range n.Children {
+		if d := c.Depth() + 1; d > res {
+			res = d
+		}
+	}
+	return res
+}
+
+// SortedChildren returns the children of the node from the biggest to the smallest.
+func (n *PrefixNode) SortedChildren() []*PrefixNode {
+	res := make([]*PrefixNode, 0, len(n.Children))
+	for _, c := range n.Children {
+		res = append(res, c)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bytes == res[j].Bytes {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Bytes > res[j].Bytes
+	})
+
+	return res
+}
+
+// prefixTree builds the tree of key prefixes.
+//
+// A key name is split with the delimiter; every segment but the last one is a
+// prefix. Segments which look like identifiers (numbers, UUIDs, hashes) are
+// replaced by a wildcard so that user:1 and user:2 are accounted together.
+type prefixTree struct {
+	delimiter string
+	depth     int
+	now       time.Time
+	nodes     int
+
+	root *PrefixNode
+}
+
+func newPrefixTree(delimiter string, depth int, now time.Time) *prefixTree {
+	return &prefixTree{
+		delimiter: delimiter,
+		depth:     depth,
+		now:       now,
+		root:      &PrefixNode{},
+	}
+}
+
+func (t *prefixTree) add(k KeyInfo) {
+	node := t.root
+	node.add(k, t.now)
+
+	for _, segment := range splitPrefixes(k.Name, t.delimiter, t.depth) {
+		if _, ok := node.Children[segment]; !ok && t.nodes >= maxPrefixNodes {
+			segment = wildcardPrefix
+		}
+		if _, ok := node.Children[segment]; !ok {
+			t.nodes++
+		}
+
+		node = node.child(segment)
+		node.add(k, t.now)
+	}
+}
+
+func (t *prefixTree) finish(s *Stats) {
+	s.Prefixes = t.root
+	s.PrefixDelimiter = t.delimiter
+}
+
+// splitPrefixes returns at most depth normalized prefix segments of key.
+func splitPrefixes(key, delimiter string, depth int) []string {
+	if delimiter == "" || depth <= 0 {
+		return nil
+	}
+
+	parts := strings.Split(key, delimiter)
+	parts = parts[:len(parts)-1]
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+
+	for i, p := range parts {
+		if isIdentifier(p) {
+			parts[i] = wildcardPrefix
+		}
+	}
+
+	return parts
+}
+
+// keyNamespace returns the prefix pattern of key, like user:* for
+// user:1:name, or noNamespace if it has no prefix.
+func keyNamespace(key, delimiter string, depth int) string {
+	segments := splitPrefixes(key, delimiter, depth)
+	if len(segments) == 0 {
+		return noNamespace
+	}
+	return strings.Join(segments, delimiter) + delimiter + wildcardPrefix
+}
+
+// topLevelPrefix returns the first prefix of key, or noNamespace if it has none.
+func topLevelPrefix(key, delimiter string) string {
+	if segments := splitPrefixes(key, delimiter, 1); len(segments) > 0 {
+		return segments[0]
+	}
+	return noNamespace
+}
+
+// boundedKey returns the key of m under which the data of name is accounted:
+// name, unless m already holds max keys without it. The names seen once the
+// maximum is reached are then accounted together in otherNamespace, which
+// bounds the memory used by the keys with many distinct prefixes.
+func boundedKey[V any](m map[string]V, name string, max int) string {
+	if _, ok := m[name]; ok || len(m) < max {
+		return name
+	}
+	return otherNamespace
+}
+
+// isIdentifier returns true if s looks like a generated identifier: a number,
+// a UUID or a long hexadecimal string.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	var digits, dashes int
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+		case r == '-':
+			dashes++
+		default:
+			return false
+		}
+	}
+
+	switch {
+	case digits == len(s):
+		return true
+	case dashes == 4 && len(s) == 36:
+		return true
+	case dashes == 0 && len(s) >= 16:
+		return true
+	}
+
+	return false
+}
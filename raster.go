@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The PNG and PDF reports are rasterized from the SVG report, so they always
+// have the same charts. The shapes are drawn by oksvg, which ignores the text
+// elements; they are drawn in a second pass with the Go font.
+
+const (
+	defaultDPI = 96 // the resolution of a browser, where 1px of the SVG is 1 pixel
+
+	defaultTextSize = 12 // pt, the default font-size of SVG
+)
+
+// outputFormat returns the format of the report file filename from its
+// extension: svg, png or pdf.
+func outputFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png":
+		return "png"
+	case ".pdf":
+		return "pdf"
+	default:
+		return "svg"
+	}
+}
+
+// rasterizeSVG draws the SVG document data in an image, dpi/defaultDPI times
+// the size of the document, on a white background.
+func rasterizeSVG(data []byte, dpi float64) (*image.RGBA, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read SVG. err=%v", err)
+	}
+
+	scale := dpi / defaultDPI
+	w := int(math.Ceil(icon.ViewBox.W * scale))
+	h := int(math.Ceil(icon.ViewBox.H * scale))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	icon.Draw(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, img.Bounds())), 1)
+
+	if err := drawSVGText(img, data, dpi); err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// svgStyle holds the properties of an element used to draw its text.
+type svgStyle map[string]string
+
+// with returns the style s overridden by the style attribute and by the
+// presentation attributes of an element.
+func (s svgStyle) with(attrs []xml.Attr) svgStyle {
+	res := make(svgStyle, len(s))
+	for k, v := range s {
+		res[k] = v
+	}
+
+	for _, a := range attrs {
+		switch a.Name.Local {
+		case "fill", "stroke", "font-size", "text-anchor":
+			res[a.Name.Local] = a.Value
+		}
+	}
+	for _, a := range attrs {
+		if a.Name.Local != "style" {
+			continue
+		}
+		for _, decl := range strings.Split(a.Value, ";") {
+			if i := strings.Index(decl, ":"); i >= 0 {
+				res[strings.TrimSpace(decl[:i])] = strings.TrimSpace(decl[i+1:])
+			}
+		}
+	}
+
+	return res
+}
+
+// fontSize returns the font size in points.
+func (s svgStyle) fontSize() float64 {
+	v := s["font-size"]
+
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(v, "pt"):
+		v = strings.TrimSuffix(v, "pt")
+	case strings.HasSuffix(v, "px"):
+		v, unit = strings.TrimSuffix(v, "px"), 0.75
+	}
+
+	size, err := strconv.ParseFloat(v, 64)
+	if err != nil || size <= 0 {
+		return defaultTextSize
+	}
+	return size * unit
+}
+
+// parseColor parses the colors written by the renderer, nil means none.
+func parseColor(v string) color.Color {
+	switch v {
+	case "", "none":
+		return nil
+	case "white":
+		return color.White
+	case "black":
+		return color.Black
+	}
+
+	v = strings.TrimPrefix(v, "#")
+	if len(v) == 3 {
+		v = string([]byte{v[0], v[0], v[1], v[1], v[2], v[2]})
+	}
+	n, err := strconv.ParseUint(v, 16, 32)
+	if err != nil || len(v) != 6 {
+		return color.Black
+	}
+	return color.RGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 0xFF}
+}
+
+// drawSVGText draws the text elements of the SVG document data in img.
+func drawSVGText(img *image.RGBA, data []byte, dpi float64) error {
+	ttf, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return fmt.Errorf("unable to parse font. err=%v", err)
+	}
+
+	faces := make(map[float64]font.Face)
+	face := func(size float64) (font.Face, error) {
+		if f, ok := faces[size]; ok {
+			return f, nil
+		}
+		f, err := opentype.NewFace(ttf, &opentype.FaceOptions{Size: size, DPI: dpi, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create font face. err=%v", err)
+		}
+		faces[size] = f
+		return f, nil
+	}
+
+	scale := dpi / defaultDPI
+
+	var (
+		dec    = xml.NewDecoder(bytes.NewReader(data))
+		styles = []svgStyle{{"fill": "black"}}
+		text   *xml.StartElement
+		chars  strings.Builder
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read SVG. err=%v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			styles = append(styles, styles[len(styles)-1].with(t.Attr))
+			if t.Name.Local == "text" {
+				text = &t
+				chars.Reset()
+			}
+
+		case xml.CharData:
+			if text != nil {
+				chars.Write(t)
+			}
+
+		case xml.EndElement:
+			style := styles[len(styles)-1]
+			styles = styles[:len(styles)-1]
+
+			if t.Name.Local != "text" || text == nil {
+				continue
+			}
+
+			var x, y float64
+			for _, a := range text.Attr {
+				switch a.Name.Local {
+				case "x":
+					x, _ = strconv.ParseFloat(a.Value, 64)
+				case "y":
+					y, _ = strconv.ParseFloat(a.Value, 64)
+				}
+			}
+			text = nil
+
+			f, err := face(style.fontSize())
+			if err != nil {
+				return err
+			}
+			drawText(img, f, x*scale, y*scale, scale, strings.TrimSpace(chars.String()), style)
+		}
+	}
+}
+
+// drawText draws s with its baseline at y and aligned on x according to the
+// text-anchor of style. A stroke is approximated by drawing the text shifted
+// around its position first.
+func drawText(img *image.RGBA, f font.Face, x, y, scale float64, s string, style svgStyle) {
+	fill := parseColor(style["fill"])
+	if fill == nil || s == "" {
+		return
+	}
+
+	d := font.Drawer{Dst: img, Face: f}
+
+	width := float64(d.MeasureString(s)) / 64
+	switch style["text-anchor"] {
+	case "middle":
+		x -= width / 2
+	case "end":
+		x -= width
+	}
+
+	at := func(dx, dy float64) fixed.Point26_6 {
+		return fixed.Point26_6{X: fixed.Int26_6((x + dx) * 64), Y: fixed.Int26_6((y + dy) * 64)}
+	}
+
+	if stroke := parseColor(style["stroke"]); stroke != nil {
+		d.Src = image.NewUniform(stroke)
+		for _, o := range [][2]float64{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}} {
+			d.Dot = at(o[0]*scale, o[1]*scale)
+			d.DrawString(s)
+		}
+	}
+
+	d.Src = image.NewUniform(fill)
+	d.Dot = at(0, 0)
+	d.DrawString(s)
+}
+
+// writePNG writes the report as a PNG image at the resolution dpi.
+func writePNG(w io.Writer, s *Stats, dpi float64) error {
+	var buf bytes.Buffer
+	if err := generateSVG(&buf, s); err != nil {
+		return err
+	}
+
+	img, err := rasterizeSVG(buf.Bytes(), dpi)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, img)
+}
+
+// writePDF writes the report as a single page PDF document. The page has the
+// size of the SVG report, 1px being 1/96 inch, and holds the report
+// rasterized at the resolution dpi.
+func writePDF(w io.Writer, s *Stats, dpi float64) error {
+	var buf bytes.Buffer
+	if err := generateSVG(&buf, s); err != nil {
+		return err
+	}
+
+	img, err := rasterizeSVG(buf.Bytes(), dpi)
+	if err != nil {
+		return err
+	}
+
+	var pixels bytes.Buffer
+	zw := zlib.NewWriter(&pixels)
+	b := img.Bounds()
+	row := make([]byte, 0, b.Dx()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			row = append(row, c.R, c.G, c.B)
+		}
+		if _, err := zw.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// Page size in points
+	pw := float64(b.Dx()) * 72 / dpi
+	ph := float64(b.Dy()) * 72 / dpi
+	content := fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q", pw, ph)
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 5 0 R >> >> /Contents 4 0 R >>", pw, ph),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+			b.Dx(), b.Dy(), pixels.Len(), pixels.Bytes()),
+	}
+
+	var (
+		doc     bytes.Buffer
+		offsets []int
+	)
+	doc.WriteString("%PDF-1.4\n")
+	for i, o := range objects {
+		offsets = append(offsets, doc.Len())
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+
+	xref := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err = doc.WriteTo(w)
+	return err
+}
+
+// writeReportFile writes the report to filename, in the format of its extension.
+func writeReportFile(filename string, s *Stats) error {
+	format := outputFormat(filename)
+	if format == "svg" {
+		return writeSVGFile(filename, s)
+	}
+	if flDPI <= 0 {
+		return fmt.Errorf("invalid resolution %g dpi", flDPI)
+	}
+
+	output, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("unable to create %s output file. err=%v", strings.ToUpper(format), err)
+	}
+	defer output.Close()
+
+	fmt.Fprintf(os.Stderr, "generating %s file...\n", strings.ToUpper(format))
+
+	if format == "png" {
+		err = writePNG(output, s, flDPI)
+	} else {
+		err = writePDF(output, s, flDPI)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to generate %s. err=%v", strings.ToUpper(format), err)
+	}
+
+	return nil
+}
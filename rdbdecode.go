@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Decoding of the values of a RDB file, shared by the header reader and the
+// native parser. The encodings are described in rdb.c, ziplist.c, listpack.c,
+// intset.c and zipmap.c of Redis.
+
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var errInvalidEncoding = errors.New("invalid encoding")
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// rdbDecoder decodes the lengths and strings of a RDB file.
+type rdbDecoder struct {
+	r byteReader
+	n int // number of bytes read
+}
+
+func (d *rdbDecoder) byte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	d.n++
+	return b, nil
+}
+
+func (d *rdbDecoder) next(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errInvalidEncoding
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	d.n += n
+	return b, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// length reads a length. If the value is a special string encoding, encoded
+// is true and the length is the encoding.
+func (d *rdbDecoder) length() (n int, encoded bool, err error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return int(b & 0x3F), false, nil
+	case 1:
+		b2, err := d.byte()
+		if err != nil {
+			return 0, false, err
+		}
+		return int(b&0x3F)<<8 | int(b2), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			v, err := d.next(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return int(binary.BigEndian.Uint32(v)), false, nil
+		case 0x81:
+			v, err := d.next(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return int(binary.BigEndian.Uint64(v)), false, nil
+		default:
+			return 0, false, fmt.Errorf("invalid length encoding 0x%02x", b)
+		}
+	default:
+		return int(b & 0x3F), true, nil
+	}
+}
+
+// uint reads a length used as a number, like the module ids.
+func (d *rdbDecoder) uint() (uint64, error) {
+	n, _, err := d.length()
+	return uint64(n), err
+}
+
+// string reads a string; the integers are returned in decimal.
+func (d *rdbDecoder) string() ([]byte, error) {
+	n, encoded, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return d.next(n)
+	}
+
+	switch n {
+	case rdbEncInt8:
+		v, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(v[0])), 10), nil
+	case rdbEncInt16:
+		v, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(v))), 10), nil
+	case rdbEncInt32:
+		v, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(v))), 10), nil
+	case rdbEncLZF:
+		clen, _, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.next(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(v, ulen)
+	default:
+		return nil, fmt.Errorf("invalid string encoding %d", n)
+	}
+}
+
+// uint32 reads a little endian 32 bits integer, like the old expiry times.
+func (d *rdbDecoder) uint32() (uint32, error) {
+	v, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(v), nil
+}
+
+// uint64 reads a little endian 64 bits integer, like the times in milliseconds.
+func (d *rdbDecoder) uint64() (uint64, error) {
+	v, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(v), nil
+}
+
+// float reads a double written as a string, the score of the old sorted sets.
+func (d *rdbDecoder) float() (float64, error) {
+	n, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	v, err := d.next(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(v), 64)
+}
+
+// binaryFloat reads a little endian double.
+func (d *rdbDecoder) binaryFloat() (float64, error) {
+	v, err := d.uint64()
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(v), nil
+}
+
+// lzfDecompress decompresses the LZF compressed data in to n bytes.
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes
+			if i+ctrl+1 > len(in) {
+				return nil, errors.New("invalid LZF data")
+			}
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+
+		// Back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errors.New("invalid LZF data")
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("invalid LZF data")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("invalid LZF data")
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != n {
+		return nil, errors.New("invalid LZF data")
+	}
+	return out, nil
+}
+
+// ziplistEntries returns the entries of a ziplist, the integers in decimal.
+func ziplistEntries(zl []byte) ([][]byte, error) {
+	if len(zl) < 11 {
+		return nil, errInvalidEncoding
+	}
+
+	var res [][]byte
+	for i := 10; ; {
+		if i >= len(zl) {
+			return nil, errInvalidEncoding
+		}
+		if zl[i] == 0xFF {
+			return res, nil
+		}
+
+		// Length of the previous entry
+		if zl[i] < 254 {
+			i++
+		} else {
+			i += 5
+		}
+		if i >= len(zl) {
+			return nil, errInvalidEncoding
+		}
+
+		b := zl[i]
+		var (
+			header, size int
+			integer      bool
+		)
+		switch {
+		case b>>6 == 0:
+			header, size = 1, int(b&0x3F)
+		case b>>6 == 1:
+			if i+1 >= len(zl) {
+				return nil, errInvalidEncoding
+			}
+			header, size = 2, int(b&0x3F)<<8|int(zl[i+1])
+		case b == 0x80:
+			if i+4 >= len(zl) {
+				return nil, errInvalidEncoding
+			}
+			header, size = 5, int(binary.BigEndian.Uint32(zl[i+1:]))
+		case b == 0xC0:
+			header, size, integer = 1, 2, true
+		case b == 0xD0:
+			header, size, integer = 1, 4, true
+		case b == 0xE0:
+			header, size, integer = 1, 8, true
+		case b == 0xF0:
+			header, size, integer = 1, 3, true
+		case b == 0xFE:
+			header, size, integer = 1, 1, true
+		case b >= 0xF1 && b <= 0xFD:
+			res = append(res, strconv.AppendInt(nil, int64(b&0x0F)-1, 10))
+			i++
+			continue
+		default:
+			return nil, errInvalidEncoding
+		}
+
+		i += header
+		if i+size > len(zl) {
+			return nil, errInvalidEncoding
+		}
+		if integer {
+			res = append(res, strconv.AppendInt(nil, littleEndianInt(zl[i:i+size]), 10))
+		} else {
+			res = append(res, zl[i:i+size])
+		}
+		i += size
+	}
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := uint(64 - len(b)*8)
+	return int64(v<<shift) >> shift
+}
+
+// listpackEntries returns the entries of a listpack, the integers in decimal.
+func listpackEntries(lp []byte) ([][]byte, error) {
+	if len(lp) < 7 {
+		return nil, errInvalidEncoding
+	}
+
+	var res [][]byte
+	for i := 6; ; {
+		if i >= len(lp) {
+			return nil, errInvalidEncoding
+		}
+
+		b := lp[i]
+		var (
+			header, size int
+			integer      bool
+			value        int64
+		)
+		switch {
+		case b == 0xFF:
+			return res, nil
+		case b&0x80 == 0:
+			header, integer, value = 1, true, int64(b&0x7F)
+		case b&0xC0 == 0x80:
+			header, size = 1, int(b&0x3F)
+		case b&0xE0 == 0xC0:
+			if i+1 >= len(lp) {
+				return nil, errInvalidEncoding
+			}
+			header, integer = 2, true
+			value = int64(b&0x1F)<<8 | int64(lp[i+1])
+			if value >= 1<<12 {
+				value -= 1 << 13
+			}
+		case b&0xF0 == 0xE0:
+			if i+1 >= len(lp) {
+				return nil, errInvalidEncoding
+			}
+			header, size = 2, int(b&0x0F)<<8|int(lp[i+1])
+		case b == 0xF0:
+			if i+4 >= len(lp) {
+				return nil, errInvalidEncoding
+			}
+			header, size = 5, int(binary.LittleEndian.Uint32(lp[i+1:]))
+		case b >= 0xF1 && b <= 0xF4:
+			n := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[b]
+			if i+1+n > len(lp) {
+				return nil, errInvalidEncoding
+			}
+			header, integer = 1+n, true
+			value = littleEndianInt(lp[i+1 : i+1+n])
+		default:
+			return nil, errInvalidEncoding
+		}
+
+		if i+header+size > len(lp) {
+			return nil, errInvalidEncoding
+		}
+		if integer {
+			res = append(res, strconv.AppendInt(nil, value, 10))
+		} else {
+			res = append(res, lp[i+header:i+header+size])
+		}
+
+		// The entry ends with its length, written on 1 to 5 bytes
+		l := header + size
+		i += l + backlenSize(l)
+	}
+}
+
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// intsetEntries returns the integers of an intset, in decimal.
+func intsetEntries(is []byte) ([][]byte, error) {
+	if len(is) < 8 {
+		return nil, errInvalidEncoding
+	}
+
+	width := int(binary.LittleEndian.Uint32(is))
+	n := int(binary.LittleEndian.Uint32(is[4:]))
+	if (width != 2 && width != 4 && width != 8) || n < 0 || 8+n*width > len(is) {
+		return nil, errInvalidEncoding
+	}
+
+	res := make([][]byte, n)
+	for i := range res {
+		at := 8 + i*width
+		res[i] = strconv.AppendInt(nil, littleEndianInt(is[at:at+width]), 10)
+	}
+	return res, nil
+}
+
+// zipmapEntries returns the fields and values of a zipmap, alternately.
+func zipmapEntries(zm []byte) ([][]byte, error) {
+	var res [][]byte
+
+	readLen := func(i int) (n, next int, err error) {
+		if i >= len(zm) {
+			return 0, 0, errInvalidEncoding
+		}
+		if zm[i] < 254 {
+			return int(zm[i]), i + 1, nil
+		}
+		if zm[i] == 254 && i+4 < len(zm) {
+			return int(binary.LittleEndian.Uint32(zm[i+1:])), i + 5, nil
+		}
+		return 0, 0, errInvalidEncoding
+	}
+
+	for i := 1; ; {
+		if i >= len(zm) {
+			return nil, errInvalidEncoding
+		}
+		if zm[i] == 0xFF {
+			return res, nil
+		}
+
+		var (
+			n   int
+			err error
+		)
+		n, i, err = readLen(i)
+		if err != nil || i+n > len(zm) {
+			return nil, errInvalidEncoding
+		}
+		field := zm[i : i+n]
+		i += n
+
+		n, i, err = readLen(i)
+		if err != nil || i+1+n > len(zm) {
+			return nil, errInvalidEncoding
+		}
+		free := int(zm[i])
+		i++
+		value := zm[i : i+n]
+		i += n + free
+
+		res = append(res, field, value)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decoder(b []byte) *rdbDecoder {
+	return &rdbDecoder{r: bytes.NewReader(b)}
+}
+
+// entries returns the entries as strings, to compare them.
+func entries(values [][]byte) []string {
+	res := make([]string, len(values))
+	for i, v := range values {
+		res[i] = string(v)
+	}
+	return res
+}
+
+func TestDecoderLength(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		n       int
+		encoded bool
+		err     bool
+	}{
+		{data: []byte{0x0A}, n: 10},
+		{data: []byte{0x41, 0x00}, n: 256},
+		{data: []byte{0x80, 0x00, 0x01, 0x00, 0x00}, n: 65536},
+		{data: []byte{0x81, 0, 0, 0, 0x01, 0, 0, 0, 0}, n: 1 << 32},
+		{data: []byte{0xC3}, n: rdbEncLZF, encoded: true},
+		{data: []byte{0x82}, err: true},
+		{data: []byte{0x41}, err: true},
+		{data: []byte{0x80, 0x00, 0x01}, err: true},
+		{data: nil, err: true},
+	}
+
+	for _, tt := range tests {
+		n, encoded, err := decoder(tt.data).length()
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("length(% x): expected an error", tt.data)
+		case !tt.err && err != nil:
+			t.Errorf("length(% x): unexpected error %v", tt.data, err)
+		case !tt.err && (n != tt.n || encoded != tt.encoded):
+			t.Errorf("length(% x) = %d, %v, expected %d, %v", tt.data, n, encoded, tt.n, tt.encoded)
+		}
+	}
+}
+
+func TestDecoderString(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  bool
+	}{
+		{name: "raw", data: []byte("\x03foo"), want: "foo"},
+		{name: "empty", data: []byte{0x00}, want: ""},
+		{name: "int8", data: []byte{0xC0, 0x85}, want: "-123"},
+		{name: "int16", data: []byte{0xC1, 0x39, 0x30}, want: "12345"},
+		{name: "int32", data: []byte{0xC2, 0x15, 0xCD, 0x5B, 0x07}, want: "123456789"},
+		{name: "lzf", data: []byte{0xC3, 0x05, 0x0A, 0x00, 'a', 0xE0, 0x00, 0x00}, want: "aaaaaaaaaa"},
+		{name: "truncated raw", data: []byte("\x05fo"), err: true},
+		{name: "truncated int16", data: []byte{0xC1, 0x39}, err: true},
+		{name: "truncated lzf", data: []byte{0xC3, 0x05, 0x0A, 0x00}, err: true},
+		{name: "unknown encoding", data: []byte{0xC4}, err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := decoder(tt.data).string()
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%s: expected an error", tt.name)
+		case !tt.err && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case !tt.err && string(v) != tt.want:
+			t.Errorf("%s: got %q, expected %q", tt.name, v, tt.want)
+		}
+	}
+}
+
+func TestDecoderCountsBytes(t *testing.T) {
+	d := decoder([]byte("\x03foo\xC1\x39\x30\x0A"))
+	d.string()
+	d.string()
+	d.length()
+	if d.n != 8 {
+		t.Errorf("read %d bytes, expected 8", d.n)
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		n    int
+		want string
+		err  bool
+	}{
+		{name: "literal", in: []byte{0x02, 'a', 'b', 'c'}, n: 3, want: "abc"},
+		{name: "short reference", in: []byte{0x02, 'a', 'b', 'c', 0x20, 0x02}, n: 6, want: "abcabc"},
+		{name: "long reference", in: []byte{0x00, 'a', 0xE0, 0x00, 0x00}, n: 10, want: "aaaaaaaaaa"},
+		{name: "truncated literal", in: []byte{0x05, 'a'}, n: 6, err: true},
+		{name: "truncated reference", in: []byte{0x00, 'a', 0x20}, n: 4, err: true},
+		{name: "reference before the start", in: []byte{0x00, 'a', 0x20, 0x05}, n: 4, err: true},
+		{name: "wrong size", in: []byte{0x02, 'a', 'b', 'c'}, n: 4, err: true},
+	}
+
+	for _, tt := range tests {
+		out, err := lzfDecompress(tt.in, tt.n)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%s: expected an error", tt.name)
+		case !tt.err && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case !tt.err && string(out) != tt.want:
+			t.Errorf("%s: got %q, expected %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestEncodedEntries(t *testing.T) {
+	ziplistHeader := make([]byte, 10) // zlbytes, zltail and zllen, not read
+	listpackHeader := make([]byte, 6) // total bytes and number of elements, not read
+
+	long := strings.Repeat("x", 200)
+
+	tests := []struct {
+		name   string
+		decode func([]byte) ([][]byte, error)
+		data   []byte
+		want   []string
+		err    bool
+	}{
+		{
+			name:   "ziplist",
+			decode: ziplistEntries,
+			data: concat(ziplistHeader,
+				[]byte{0x00, 0x02, 'a', 'b'},                     // string
+				[]byte{0x04, 0xFD},                               // immediate 12
+				[]byte{0x02, 0xC0, 0xFE, 0xFF},                   // int16 -2
+				[]byte{0x04, 0xF0, 0x00, 0x00, 0x80},             // int24 -8388608
+				[]byte{0xFE, 0x00, 0x00, 0x00, 0x00, 0xFE, 0x07}, // 5 bytes prevlen, int8 7
+				[]byte{0xFF}),
+			want: []string{"ab", "12", "-2", "-8388608", "7"},
+		},
+		{
+			name:   "ziplist 14 bits string",
+			decode: ziplistEntries,
+			data:   concat(ziplistHeader, []byte{0x00, 0x40, 200}, []byte(long), []byte{0xFF}),
+			want:   []string{long},
+		},
+		{
+			name:   "truncated ziplist",
+			decode: ziplistEntries,
+			data:   concat(ziplistHeader, []byte{0x00, 0x02, 'a', 'b'}),
+			err:    true,
+		},
+		{
+			name:   "ziplist string past the end",
+			decode: ziplistEntries,
+			data:   concat(ziplistHeader, []byte{0x00, 0x05, 'a', 0xFF}),
+			err:    true,
+		},
+		{
+			name:   "listpack",
+			decode: listpackEntries,
+			data: concat(listpackHeader,
+				[]byte{0x05, 0x01},                         // 7 bits uint 5
+				[]byte{0x82, 'h', 'i', 0x03},               // 6 bits string
+				[]byte{0xDF, 0xFF, 0x02},                   // 13 bits int -1
+				[]byte{0xF1, 0xE8, 0x03, 0x03},             // int16 1000
+				[]byte{0xF3, 0x00, 0x00, 0x00, 0x80, 0x05}, // int32
+				[]byte{0xFF}),
+			want: []string{"5", "hi", "-1", "1000", "-2147483648"},
+		},
+		{
+			name:   "listpack 2 bytes backlen",
+			decode: listpackEntries,
+			data:   concat(listpackHeader, []byte{0xE0, 200}, []byte(long), []byte{0x01, 0x4A}, []byte{0x07, 0x01}, []byte{0xFF}),
+			want:   []string{long, "7"},
+		},
+		{
+			name:   "truncated listpack",
+			decode: listpackEntries,
+			data:   concat(listpackHeader, []byte{0x82, 'h'}),
+			err:    true,
+		},
+		{
+			name:   "listpack without end",
+			decode: listpackEntries,
+			data:   concat(listpackHeader, []byte{0x05, 0x01}),
+			err:    true,
+		},
+		{
+			name:   "intset",
+			decode: intsetEntries,
+			data:   []byte{0x02, 0, 0, 0, 0x03, 0, 0, 0, 0x01, 0x00, 0xFF, 0xFF, 0x00, 0x80},
+			want:   []string{"1", "-1", "-32768"},
+		},
+		{
+			name:   "intset of int64",
+			decode: intsetEntries,
+			data:   []byte{0x08, 0, 0, 0, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80},
+			want:   []string{"-9223372036854775808"},
+		},
+		{
+			name:   "intset invalid width",
+			decode: intsetEntries,
+			data:   []byte{0x03, 0, 0, 0, 0x01, 0, 0, 0, 0x01, 0x00, 0x00},
+			err:    true,
+		},
+		{
+			name:   "truncated intset",
+			decode: intsetEntries,
+			data:   []byte{0x02, 0, 0, 0, 0x03, 0, 0, 0, 0x01, 0x00},
+			err:    true,
+		},
+		{
+			name:   "zipmap",
+			decode: zipmapEntries,
+			data:   []byte{0x02, 0x01, 'f', 0x02, 0x00, 'v', '1', 0x01, 'a', 0x01, 0x02, 'b', 0x00, 0x00, 0xFF},
+			want:   []string{"f", "v1", "a", "b"},
+		},
+		{
+			name:   "truncated zipmap",
+			decode: zipmapEntries,
+			data:   []byte{0x01, 0x01, 'f', 0x02, 0x00, 'v'},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		values, err := tt.decode(tt.data)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%s: expected an error", tt.name)
+		case !tt.err && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case !tt.err && !reflect.DeepEqual(entries(values), tt.want):
+			t.Errorf("%s: got %q, expected %q", tt.name, entries(values), tt.want)
+		}
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The header of a RDB file holds its version, the AUX fields written by Redis
+// and, after the SELECTDB opcode of each database, a RESIZEDB opcode with the
+// size of its hash tables. rdbtools skips them, so they are read separately
+// from the beginning of the file, which is peeked without consuming it. The
+// native parser, used for the newer files, also records the hints of all the
+// databases.
+
+const (
+	// rdbHeaderPeekSize is the maximum size of the header read, the AUX
+	// fields and hints after it are ignored.
+	rdbHeaderPeekSize = 64 * 1024
+)
+
+// RDBInfo is the metadata of a RDB file, read from its header.
+type RDBInfo struct {
+	Version int
+	Aux     map[string]string `json:",omitempty"`
+
+	// Resize holds the RESIZEDB hints. When the file is read by rdbtools only
+	// the hints of the databases before the first key are known, which is
+	// usually the first database.
+	Resize []ResizeHint `json:",omitempty"`
+}
+
+// ResizeHint is the number of keys and of keys with an expiry of a database,
+// as recorded by Redis when writing the file.
+type ResizeHint struct {
+	DB       int
+	Keys     int
+	Expiring int
+}
+
+// RedisVersion returns the version of Redis which wrote the file, or an empty
+// string if it is unknown.
+func (i *RDBInfo) RedisVersion() string {
+	return i.Aux["redis-ver"]
+}
+
+// CreationTime returns the time the file was written, or the zero time if it
+// is unknown.
+func (i *RDBInfo) CreationTime() time.Time {
+	ctime, err := strconv.ParseInt(i.Aux["ctime"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(ctime, 0)
+}
+
+// UsedMemory returns the memory used by Redis when it wrote the file, or 0 if
+// it is unknown.
+func (i *RDBInfo) UsedMemory() int {
+	n, _ := strconv.Atoi(i.Aux["used-mem"])
+	return n
+}
+
+// AuxNames returns the names of the AUX fields, sorted.
+func (i *RDBInfo) AuxNames() []string {
+	var res []string
+	for name := range i.Aux {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// readRDBInfo reads the header of the RDB file read by br, without consuming
+// it. It returns nil if the data doesn't start with a RDB header.
+func readRDBInfo(br *bufio.Reader) *RDBInfo {
+	data, _ := br.Peek(rdbHeaderPeekSize)
+
+	if len(data) < 9 || string(data[:5]) != "REDIS" {
+		return nil
+	}
+	version, err := strconv.Atoi(string(data[5:9]))
+	if err != nil {
+		return nil
+	}
+
+	info := &RDBInfo{Version: version}
+	r := &rdbDecoder{r: bytes.NewReader(data[9:])}
+
+	db := 0
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return info
+		}
+
+		switch op {
+		case rdbOpAux:
+			name, err := r.string()
+			if err != nil {
+				return info
+			}
+			value, err := r.string()
+			if err != nil {
+				return info
+			}
+			if info.Aux == nil {
+				info.Aux = make(map[string]string)
+			}
+			info.Aux[string(name)] = string(value)
+
+		case rdbOpSelectDB:
+			n, _, err := r.length()
+			if err != nil {
+				return info
+			}
+			db = n
+
+		case rdbOpResizeDB:
+			keys, _, err := r.length()
+			if err != nil {
+				return info
+			}
+			expiring, _, err := r.length()
+			if err != nil {
+				return info
+			}
+			info.Resize = append(info.Resize, ResizeHint{DB: db, Keys: keys, Expiring: expiring})
+
+		default:
+			// The first key, or an opcode after which there's no header left
+			return info
+		}
+	}
+}
@@ -0,0 +1,785 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vrischmann/rdbtools"
+)
+
+// rdbtools reads the RDB files up to version 6, written by Redis 3.0. The
+// newer files are read by the native parser below, which also understands the
+// types added since: modules, streams, functions and the listpack encodings.
+const rdbtoolsMaxVersion = 6
+
+// The opcodes and value types of the RDB format, from rdb.h of Redis.
+const (
+	rdbOpSlotInfo         = 0xF4
+	rdbOpFunction2        = 0xF5
+	rdbOpFunctionPreGA    = 0xF6
+	rdbOpModuleAux        = 0xF7
+	rdbOpIdle             = 0xF8
+	rdbOpFreq             = 0xF9
+	rdbOpAux              = 0xFA
+	rdbOpResizeDB         = 0xFB
+	rdbOpExpireTimeMs     = 0xFC
+	rdbOpExpireTime       = 0xFD
+	rdbOpSelectDB         = 0xFE
+	rdbOpEOF              = 0xFF
+	rdbTypeString         = 0
+	rdbTypeList           = 1
+	rdbTypeSet            = 2
+	rdbTypeZSet           = 3
+	rdbTypeHash           = 4
+	rdbTypeZSet2          = 5
+	rdbTypeModulePreGA    = 6
+	rdbTypeModule2        = 7
+	rdbTypeHashZipmap     = 9
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZSetZiplist    = 12
+	rdbTypeHashZiplist    = 13
+	rdbTypeListQuicklist  = 14
+	rdbTypeStream         = 15
+	rdbTypeHashListpack   = 16
+	rdbTypeZSetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeStream2        = 19
+	rdbTypeSetListpack    = 20
+	rdbTypeStream3        = 21
+	rdbTypeHashMetadata   = 24
+	rdbTypeHashListpackEx = 25
+
+	// The opcodes of the values of the module types
+	rdbModuleOpEOF    = 0
+	rdbModuleOpSInt   = 1
+	rdbModuleOpUInt   = 2
+	rdbModuleOpFloat  = 3
+	rdbModuleOpDouble = 4
+	rdbModuleOpString = 5
+
+	quicklistNodePlain = 1
+)
+
+// streamObject is a stream key.
+type streamObject struct {
+	Key            rdbtools.KeyObject
+	Bytes          int // of the listpacks of the entries
+	Entries        int
+	Groups         int
+	Consumers      int
+	PendingEntries int
+}
+
+// moduleObject is a key of a type defined by a module.
+type moduleObject struct {
+	Key    rdbtools.KeyObject
+	Module string // name of the module type
+	Bytes  int    // of the serialized value
+}
+
+// functionObject is a library of functions.
+type functionObject struct {
+	Bytes int // of the code
+}
+
+// parserContext holds the channels of rdbtools and the channels of the newer
+// types, only sent by the native parser.
+type parserContext struct {
+	rdbtools.ParserContext
+
+	StreamCh   chan streamObject
+	ModuleCh   chan moduleObject
+	FunctionCh chan functionObject
+
+	// EncodingCh receives the encoding of each key before the key.
+	EncodingCh chan string
+}
+
+func newParserContext() parserContext {
+	return parserContext{
+		ParserContext: rdbtools.ParserContext{
+			DbCh:                make(chan int),
+			StringObjectCh:      make(chan rdbtools.StringObject),
+			ListMetadataCh:      make(chan rdbtools.ListMetadata),
+			ListDataCh:          make(chan interface{}),
+			SetMetadataCh:       make(chan rdbtools.SetMetadata),
+			SetDataCh:           make(chan interface{}),
+			HashMetadataCh:      make(chan rdbtools.HashMetadata),
+			HashDataCh:          make(chan rdbtools.HashEntry),
+			SortedSetMetadataCh: make(chan rdbtools.SortedSetMetadata),
+			SortedSetEntriesCh:  make(chan rdbtools.SortedSetEntry),
+		},
+		StreamCh:   make(chan streamObject),
+		ModuleCh:   make(chan moduleObject),
+		FunctionCh: make(chan functionObject),
+		EncodingCh: make(chan string),
+	}
+}
+
+// closeExtended closes the channels which are not closed by rdbtools.
+func (c parserContext) closeExtended() {
+	close(c.StreamCh)
+	close(c.ModuleCh)
+	close(c.FunctionCh)
+	close(c.EncodingCh)
+}
+
+func (c parserContext) closeAll() {
+	close(c.DbCh)
+	close(c.StringObjectCh)
+	close(c.ListMetadataCh)
+	close(c.ListDataCh)
+	close(c.SetMetadataCh)
+	close(c.SetDataCh)
+	close(c.HashMetadataCh)
+	close(c.HashDataCh)
+	close(c.SortedSetMetadataCh)
+	close(c.SortedSetEntriesCh)
+	c.closeExtended()
+}
+
+// rdbParser reads a RDB file and sends its objects in file order, like
+// rdbtools does.
+type rdbParser struct {
+	ctx  parserContext
+	info *RDBInfo // completed with the RESIZEDB hints of all the databases
+	d    rdbDecoder
+
+	db     int
+	expiry time.Time // of the next key
+}
+
+func newRDBParser(ctx parserContext, info *RDBInfo) *rdbParser {
+	return &rdbParser{ctx: ctx, info: info}
+}
+
+// parse reads the RDB file from r, which starts with the header, and closes
+// the channels of the context once the whole file is read.
+func (p *rdbParser) parse(r byteReader) error {
+	p.d = rdbDecoder{r: r}
+
+	if _, err := p.d.next(9); err != nil {
+		return fmt.Errorf("unable to read header. err=%v", err)
+	}
+
+	for {
+		start := p.d.n // of the opcode, the header included
+		op, err := p.d.byte()
+		if err != nil {
+			return err
+		}
+
+		if op == rdbOpEOF {
+			// The checksum which follows is not verified
+			p.ctx.closeAll()
+			return nil
+		}
+
+		if err := p.readOpcode(op); err != nil {
+			return fmt.Errorf("at offset %d: %v", start, err)
+		}
+	}
+}
+
+func (p *rdbParser) readOpcode(op byte) error {
+	d := &p.d
+
+	switch op {
+	case rdbOpSelectDB:
+		db, _, err := d.length()
+		if err != nil {
+			return err
+		}
+		p.db = db
+		p.ctx.DbCh <- db
+
+	case rdbOpResizeDB:
+		keys, _, err := d.length()
+		if err != nil {
+			return err
+		}
+		expiring, _, err := d.length()
+		if err != nil {
+			return err
+		}
+		p.addResizeHint(ResizeHint{DB: p.db, Keys: keys, Expiring: expiring})
+
+	case rdbOpAux:
+		if _, err := d.string(); err != nil {
+			return err
+		}
+		if _, err := d.string(); err != nil {
+			return err
+		}
+
+	case rdbOpExpireTime:
+		sec, err := d.uint32()
+		if err != nil {
+			return err
+		}
+		p.expiry = time.Unix(int64(sec), 0)
+
+	case rdbOpExpireTimeMs:
+		ms, err := d.uint64()
+		if err != nil {
+			return err
+		}
+		p.expiry = time.Unix(0, int64(ms)*int64(time.Millisecond))
+
+	case rdbOpIdle:
+		if _, _, err := d.length(); err != nil {
+			return err
+		}
+
+	case rdbOpFreq:
+		if _, err := d.byte(); err != nil {
+			return err
+		}
+
+	case rdbOpSlotInfo:
+		for i := 0; i < 3; i++ {
+			if _, _, err := d.length(); err != nil {
+				return err
+			}
+		}
+
+	case rdbOpModuleAux:
+		// The module type id, then when the data was saved, before or after
+		// the keys, as a module unsigned integer
+		if _, err := d.uint(); err != nil {
+			return err
+		}
+		whenOp, _, err := d.length()
+		if err != nil {
+			return err
+		}
+		if whenOp != rdbModuleOpUInt {
+			return fmt.Errorf("invalid module aux when opcode %d", whenOp)
+		}
+		if _, _, err := d.length(); err != nil {
+			return err
+		}
+		if _, err := p.skipModuleValue(); err != nil {
+			return err
+		}
+
+	case rdbOpFunction2:
+		code, err := d.string()
+		if err != nil {
+			return err
+		}
+		p.ctx.FunctionCh <- functionObject{Bytes: len(code)}
+
+	case rdbOpFunctionPreGA:
+		return errors.New("functions saved by a release candidate of Redis 7.0 are not supported")
+
+	default:
+		key, err := d.string()
+		if err != nil {
+			return err
+		}
+
+		obj := rdbtools.KeyObject{ExpiryTime: p.expiry, Key: key}
+		p.expiry = time.Time{}
+
+		return p.readValue(op, obj)
+	}
+
+	return nil
+}
+
+func (p *rdbParser) addResizeHint(h ResizeHint) {
+	if p.info == nil {
+		return
+	}
+	for _, r := range p.info.Resize {
+		if r.DB == h.DB {
+			return
+		}
+	}
+	p.info.Resize = append(p.info.Resize, h)
+}
+
+// rdbTypeEncodings are the encodings of the value types of the collections.
+var rdbTypeEncodings = map[byte]string{
+	rdbTypeList:           linkedListEncoding,
+	rdbTypeSet:            hashtableEncoding,
+	rdbTypeZSet:           skiplistEncoding,
+	rdbTypeHash:           hashtableEncoding,
+	rdbTypeZSet2:          skiplistEncoding,
+	rdbTypeHashZipmap:     zipmapEncoding,
+	rdbTypeListZiplist:    ziplistEncoding,
+	rdbTypeSetIntset:      intsetEncoding,
+	rdbTypeZSetZiplist:    ziplistEncoding,
+	rdbTypeHashZiplist:    ziplistEncoding,
+	rdbTypeListQuicklist:  quicklistEncoding,
+	rdbTypeStream:         streamEncoding,
+	rdbTypeHashListpack:   listpackEncoding,
+	rdbTypeZSetListpack:   listpackEncoding,
+	rdbTypeListQuicklist2: quicklistEncoding,
+	rdbTypeStream2:        streamEncoding,
+	rdbTypeSetListpack:    listpackEncoding,
+	rdbTypeStream3:        streamEncoding,
+	rdbTypeHashMetadata:   hashtableEncoding,
+	rdbTypeHashListpackEx: listpackEncoding,
+}
+
+func (p *rdbParser) readValue(typ byte, key rdbtools.KeyObject) error {
+	d := &p.d
+
+	if encoding, ok := rdbTypeEncodings[typ]; ok {
+		p.ctx.EncodingCh <- encoding
+	}
+
+	switch typ {
+	case rdbTypeString:
+		v, err := d.string()
+		if err != nil {
+			return err
+		}
+		p.ctx.EncodingCh <- stringEncoding(v)
+		p.ctx.StringObjectCh <- rdbtools.StringObject{Key: key, Value: v}
+
+	case rdbTypeList, rdbTypeSet:
+		values, err := p.readStrings(1)
+		if err != nil {
+			return err
+		}
+		if typ == rdbTypeList {
+			p.sendList(key, values)
+		} else {
+			p.sendSet(key, values)
+		}
+
+	case rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeSetListpack:
+		decode := map[byte]func([]byte) ([][]byte, error){
+			rdbTypeListZiplist: ziplistEntries,
+			rdbTypeSetIntset:   intsetEntries,
+			rdbTypeSetListpack: listpackEntries,
+		}[typ]
+
+		values, err := p.readEncoded(decode)
+		if err != nil {
+			return err
+		}
+		if typ == rdbTypeListZiplist {
+			p.sendList(key, values)
+		} else {
+			p.sendSet(key, values)
+		}
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		values, err := p.readQuicklist(typ == rdbTypeListQuicklist2)
+		if err != nil {
+			return err
+		}
+		p.sendList(key, values)
+
+	case rdbTypeHash:
+		values, err := p.readStrings(2)
+		if err != nil {
+			return err
+		}
+		p.sendHash(key, values, 2)
+
+	case rdbTypeHashZipmap, rdbTypeHashZiplist, rdbTypeHashListpack:
+		decode := map[byte]func([]byte) ([][]byte, error){
+			rdbTypeHashZipmap:   zipmapEntries,
+			rdbTypeHashZiplist:  ziplistEntries,
+			rdbTypeHashListpack: listpackEntries,
+		}[typ]
+
+		values, err := p.readEncoded(decode)
+		if err != nil {
+			return err
+		}
+		p.sendHash(key, values, 2)
+
+	case rdbTypeHashMetadata:
+		values, err := p.readHashMetadata()
+		if err != nil {
+			return err
+		}
+		p.sendHash(key, values, 2)
+
+	case rdbTypeHashListpackEx:
+		// The minimum expiry time of the fields, then field, value and
+		// expiry time triplets
+		if _, err := d.uint64(); err != nil {
+			return err
+		}
+		values, err := p.readEncoded(listpackEntries)
+		if err != nil {
+			return err
+		}
+		p.sendHash(key, values, 3)
+
+	case rdbTypeZSet, rdbTypeZSet2:
+		n, _, err := d.length()
+		if err != nil {
+			return err
+		}
+
+		entries := make([]rdbtools.SortedSetEntry, n)
+		for i := range entries {
+			v, err := d.string()
+			if err != nil {
+				return err
+			}
+
+			var score float64
+			if typ == rdbTypeZSet2 {
+				score, err = d.binaryFloat()
+			} else {
+				score, err = d.float()
+			}
+			if err != nil {
+				return err
+			}
+
+			entries[i] = rdbtools.SortedSetEntry{Value: v, Score: score}
+		}
+		p.sendSortedSet(key, entries)
+
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		decode := ziplistEntries
+		if typ == rdbTypeZSetListpack {
+			decode = listpackEntries
+		}
+
+		values, err := p.readEncoded(decode)
+		if err != nil {
+			return err
+		}
+		if len(values)%2 != 0 {
+			return errInvalidEncoding
+		}
+
+		entries := make([]rdbtools.SortedSetEntry, len(values)/2)
+		for i := range entries {
+			score, _ := strconv.ParseFloat(string(values[i*2+1]), 64)
+			entries[i] = rdbtools.SortedSetEntry{Value: values[i*2], Score: score}
+		}
+		p.sendSortedSet(key, entries)
+
+	case rdbTypeModule2:
+		id, err := d.uint()
+		if err != nil {
+			return err
+		}
+		n, err := p.skipModuleValue()
+		if err != nil {
+			return err
+		}
+		name := moduleTypeName(id)
+		p.ctx.EncodingCh <- name
+		p.ctx.ModuleCh <- moduleObject{Key: key, Module: name, Bytes: n}
+
+	case rdbTypeModulePreGA:
+		id, err := d.uint()
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("the values of the module type %s are saved in a format which can't be read without the module", moduleTypeName(id))
+
+	case rdbTypeStream, rdbTypeStream2, rdbTypeStream3:
+		obj, err := p.readStream(typ)
+		if err != nil {
+			return err
+		}
+		obj.Key = key
+		p.ctx.StreamCh <- obj
+
+	default:
+		return fmt.Errorf("unsupported value type %d", typ)
+	}
+
+	return nil
+}
+
+// readStrings reads a length, then length times group strings.
+func (p *rdbParser) readStrings(group int) ([][]byte, error) {
+	n, _, err := p.d.length()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, 0, n*group)
+	for i := 0; i < n*group; i++ {
+		v, err := p.d.string()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// readEncoded reads a string and decodes its entries with decode.
+func (p *rdbParser) readEncoded(decode func([]byte) ([][]byte, error)) ([][]byte, error) {
+	v, err := p.d.string()
+	if err != nil {
+		return nil, err
+	}
+	return decode(v)
+}
+
+// readQuicklist reads the nodes of a quicklist, ziplists or, for the second
+// version, plain elements or listpacks.
+func (p *rdbParser) readQuicklist(v2 bool) ([][]byte, error) {
+	n, _, err := p.d.length()
+	if err != nil {
+		return nil, err
+	}
+
+	var res [][]byte
+	for i := 0; i < n; i++ {
+		container := 0
+		if v2 {
+			if container, _, err = p.d.length(); err != nil {
+				return nil, err
+			}
+		}
+
+		node, err := p.d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		var values [][]byte
+		switch {
+		case !v2:
+			values, err = ziplistEntries(node)
+		case container == quicklistNodePlain:
+			values = [][]byte{node}
+		default:
+			values, err = listpackEntries(node)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, values...)
+	}
+
+	return res, nil
+}
+
+// readHashMetadata reads the fields and values of a hash with expiring fields.
+func (p *rdbParser) readHashMetadata() ([][]byte, error) {
+	d := &p.d
+
+	// The minimum expiry time of the fields
+	if _, err := d.uint64(); err != nil {
+		return nil, err
+	}
+
+	n, _, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, 0, n*2)
+	for i := 0; i < n; i++ {
+		// The expiry time of the field, relative to the minimum
+		if _, _, err := d.length(); err != nil {
+			return nil, err
+		}
+		for j := 0; j < 2; j++ {
+			v, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+	}
+
+	return res, nil
+}
+
+// readStream reads a stream, counting its entries, consumer groups,
+// consumers and pending entries.
+func (p *rdbParser) readStream(typ byte) (streamObject, error) {
+	d := &p.d
+
+	var res streamObject
+
+	nodes, _, err := d.length()
+	if err != nil {
+		return res, err
+	}
+	for i := 0; i < nodes; i++ {
+		// The master ID of the node, then the listpack of its entries
+		if _, err := d.string(); err != nil {
+			return res, err
+		}
+		lp, err := d.string()
+		if err != nil {
+			return res, err
+		}
+		res.Bytes += len(lp)
+	}
+
+	// The number of entries, then the last ID
+	fields := 3
+	if typ != rdbTypeStream {
+		// The first ID, the maximal deleted ID and the number of entries added
+		fields += 5
+	}
+	for i := 0; i < fields; i++ {
+		n, _, err := d.length()
+		if err != nil {
+			return res, err
+		}
+		if i == 0 {
+			res.Entries = n
+		}
+	}
+
+	groups, _, err := d.length()
+	if err != nil {
+		return res, err
+	}
+	res.Groups = groups
+
+	for i := 0; i < groups; i++ {
+		if _, err := d.string(); err != nil {
+			return res, err
+		}
+
+		// The last delivered ID and, since the second version, the number
+		// of entries read
+		fields := 2
+		if typ != rdbTypeStream {
+			fields++
+		}
+		for j := 0; j < fields; j++ {
+			if _, _, err := d.length(); err != nil {
+				return res, err
+			}
+		}
+
+		// The pending entries: ID, delivery time and delivery count
+		pending, _, err := d.length()
+		if err != nil {
+			return res, err
+		}
+		res.PendingEntries += pending
+		for j := 0; j < pending; j++ {
+			if _, err := d.next(16 + 8); err != nil {
+				return res, err
+			}
+			if _, _, err := d.length(); err != nil {
+				return res, err
+			}
+		}
+
+		consumers, _, err := d.length()
+		if err != nil {
+			return res, err
+		}
+		res.Consumers += consumers
+
+		for j := 0; j < consumers; j++ {
+			if _, err := d.string(); err != nil {
+				return res, err
+			}
+
+			// The seen time and, since the third version, the active time
+			times := 1
+			if typ == rdbTypeStream3 {
+				times++
+			}
+			if _, err := d.next(8 * times); err != nil {
+				return res, err
+			}
+
+			// The IDs of the pending entries of the consumer
+			n, _, err := d.length()
+			if err != nil {
+				return res, err
+			}
+			if _, err := d.next(16 * n); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// skipModuleValue skips a value serialized by a module and returns its size.
+func (p *rdbParser) skipModuleValue() (int, error) {
+	d := &p.d
+	start := d.n
+
+	for {
+		op, _, err := d.length()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case rdbModuleOpEOF:
+			return d.n - start, nil
+		case rdbModuleOpSInt, rdbModuleOpUInt:
+			_, _, err = d.length()
+		case rdbModuleOpFloat:
+			_, err = d.next(4)
+		case rdbModuleOpDouble:
+			_, err = d.next(8)
+		case rdbModuleOpString:
+			_, err = d.string()
+		default:
+			return 0, fmt.Errorf("invalid module value opcode %d", op)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+const moduleTypeNameCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// moduleTypeName returns the name of the module type of the id: 9 characters
+// of 6 bits, followed by 10 bits of encoding version.
+func moduleTypeName(id uint64) string {
+	name := make([]byte, 9)
+	for i := range name {
+		name[i] = moduleTypeNameCharset[(id>>(64-6*uint(i+1)))&63]
+	}
+	return string(name)
+}
+
+func (p *rdbParser) sendList(key rdbtools.KeyObject, values [][]byte) {
+	p.ctx.ListMetadataCh <- rdbtools.ListMetadata{Key: key, Len: int64(len(values))}
+	for _, v := range values {
+		p.ctx.ListDataCh <- v
+	}
+}
+
+func (p *rdbParser) sendSet(key rdbtools.KeyObject, values [][]byte) {
+	p.ctx.SetMetadataCh <- rdbtools.SetMetadata{Key: key, Len: int64(len(values))}
+	for _, v := range values {
+		p.ctx.SetDataCh <- v
+	}
+}
+
+// sendHash sends the fields and values, in groups of size values of which the
+// first two are the field and the value.
+func (p *rdbParser) sendHash(key rdbtools.KeyObject, values [][]byte, size int) {
+	p.ctx.HashMetadataCh <- rdbtools.HashMetadata{Key: key, Len: int64(len(values) / size)}
+	for i := 0; i+1 < len(values); i += size {
+		p.ctx.HashDataCh <- rdbtools.HashEntry{Key: values[i], Value: values[i+1]}
+	}
+}
+
+func (p *rdbParser) sendSortedSet(key rdbtools.KeyObject, entries []rdbtools.SortedSetEntry) {
+	p.ctx.SortedSetMetadataCh <- rdbtools.SortedSetMetadata{Key: key, Len: int64(len(entries))}
+	for _, e := range entries {
+		p.ctx.SortedSetEntriesCh <- e
+	}
+}
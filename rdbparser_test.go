@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// rdbFile returns a RDB file of version 11 holding the objects of body.
+func rdbFile(body ...[]byte) []byte {
+	res := concat([]byte("REDIS0011"), concat(body...), []byte{rdbOpEOF})
+	return append(res, make([]byte, 8)...) // checksum, not verified
+}
+
+// rdbString returns a short string in the RDB encoding.
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+// Module type MyModule1, encoding version 3
+var moduleID = []byte{0x81, 0x33, 0x23, 0x28, 0x76, 0xE9, 0x5E, 0xD4, 0x03}
+
+// moduleValue is a value serialized by a module, with all the opcodes.
+var moduleValue = concat(
+	[]byte{rdbModuleOpSInt, 0x05},
+	[]byte{rdbModuleOpFloat, 0, 0, 0x80, 0x3F},
+	[]byte{rdbModuleOpDouble, 0, 0, 0, 0, 0, 0, 0xF0, 0x3F},
+	[]byte{rdbModuleOpString}, rdbString("xy"),
+	[]byte{rdbModuleOpEOF},
+)
+
+// emptyListpack is a listpack without entries.
+var emptyListpack = []byte{0x07, 0, 0, 0, 0, 0, 0xFF}
+
+// stream returns a stream of a node and a consumer group with a consumer and
+// a pending entry, in the format of typ.
+func stream(typ byte) []byte {
+	streamID := make([]byte, 16)
+
+	res := concat([]byte{typ}, rdbString("s"),
+		[]byte{0x01}, rdbString(string(streamID)), rdbString(string(emptyListpack)),
+		[]byte{0x03, 0x01, 0x00}) // entries, last ID
+	if typ != rdbTypeStream {
+		res = concat(res, []byte{0x00, 0x00, 0x00, 0x00, 0x03}) // first ID, maximal deleted ID, entries added
+	}
+
+	res = concat(res, []byte{0x01}, rdbString("g"), []byte{0x01, 0x00}) // last delivered ID
+	if typ != rdbTypeStream {
+		res = append(res, 0x03) // entries read
+	}
+	res = concat(res, []byte{0x01}, make([]byte, 16+8), []byte{0x01}) // pending entry
+
+	res = concat(res, []byte{0x01}, rdbString("c"), make([]byte, 8)) // seen time
+	if typ == rdbTypeStream3 {
+		res = append(res, make([]byte, 8)...) // active time
+	}
+	return concat(res, []byte{0x01}, make([]byte, 16)) // pending entry of the consumer
+}
+
+func TestParser(t *testing.T) {
+	listpack := concat(make([]byte, 6), []byte{0x82, 'a', 'b', 0x03, 0x0C, 0x01, 0xFF})
+
+	tests := []struct {
+		name  string
+		body  [][]byte
+		check func(s *Stats) bool
+	}{
+		{
+			name: "strings",
+			body: [][]byte{
+				{rdbOpAux}, rdbString("redis-ver"), rdbString("7.2.0"),
+				{rdbOpSelectDB, 0x00, rdbOpResizeDB, 0x02, 0x00},
+				{rdbTypeString}, rdbString("a"), rdbString("value"),
+				{rdbTypeString}, rdbString("b"), {0xC3, 0x05, 0x0A, 0x00, 'a', 0xE0, 0x00, 0x00},
+			},
+			check: func(s *Stats) bool { return s.Strings.Count == 2 && s.Strings.TotalByteSize == 15 },
+		},
+		{
+			name: "quicklist2",
+			body: [][]byte{
+				{rdbTypeListQuicklist2}, rdbString("l"), {0x02},
+				{0x02}, rdbString(string(listpack)), // packed node
+				{quicklistNodePlain}, rdbString("plain"),
+			},
+			check: func(s *Stats) bool { return s.Lists.Count == 1 && s.Lists.TotalByteSize == 2+2+5 },
+		},
+		{
+			name:  "set listpack",
+			body:  [][]byte{{rdbTypeSetListpack}, rdbString("s"), rdbString(string(listpack))},
+			check: func(s *Stats) bool { return s.Sets.Count == 1 && s.Sets.TotalByteSize == 4 },
+		},
+		{
+			name: "stream v1",
+			body: [][]byte{stream(rdbTypeStream)},
+			check: func(s *Stats) bool {
+				return s.Streams == StreamStats{Count: 1, TotalByteSize: 7, Entries: 3, Groups: 1, Consumers: 1, PendingEntries: 1}
+			},
+		},
+		{
+			name: "stream v2",
+			body: [][]byte{stream(rdbTypeStream2)},
+			check: func(s *Stats) bool {
+				return s.Streams == StreamStats{Count: 1, TotalByteSize: 7, Entries: 3, Groups: 1, Consumers: 1, PendingEntries: 1}
+			},
+		},
+		{
+			name: "stream v3",
+			body: [][]byte{stream(rdbTypeStream3)},
+			check: func(s *Stats) bool {
+				return s.Streams == StreamStats{Count: 1, TotalByteSize: 7, Entries: 3, Groups: 1, Consumers: 1, PendingEntries: 1}
+			},
+		},
+		{
+			name: "module value",
+			body: [][]byte{{rdbTypeModule2}, rdbString("m"), moduleID, moduleValue},
+			check: func(s *Stats) bool {
+				return s.Modules.Count == 1 && s.Modules.TotalByteSize == len(moduleValue) && s.Modules.Types["MyModule1"] == 1
+			},
+		},
+		{
+			name: "module aux",
+			body: [][]byte{
+				{rdbOpModuleAux}, moduleID, {rdbModuleOpUInt, 0x02}, moduleValue,
+				{rdbTypeString}, rdbString("a"), rdbString("value"),
+			},
+			check: func(s *Stats) bool { return s.Keys.Count == 1 && s.Modules.Count == 0 },
+		},
+		{
+			name:  "function",
+			body:  [][]byte{{rdbOpFunction2}, rdbString("#!lua name=lib")},
+			check: func(s *Stats) bool { return s.Functions.Libraries == 1 && s.Functions.TotalByteSize == 14 },
+		},
+	}
+
+	for _, tt := range tests {
+		data := rdbFile(tt.body...)
+
+		var s Stats
+		if err := analyzeRDB(bytes.NewReader(data), &s); err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !tt.check(&s) {
+			t.Errorf("%s: unexpected stats %+v", tt.name, s)
+		}
+
+		// Cut anywhere after the header and before the end, the file is invalid
+		for n := 9; n < len(data)-8; n++ {
+			var s Stats
+			if err := analyzeRDB(bytes.NewReader(data[:n]), &s); err == nil {
+				t.Errorf("%s: no error for the file truncated to %d bytes", tt.name, n)
+			}
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body [][]byte
+		err  string
+	}{
+		{
+			name: "offset of the object",
+			body: [][]byte{{rdbOpSelectDB, 0x00, 0x63}, rdbString("k")},
+			err:  "at offset 11: unsupported value type 99",
+		},
+		{
+			name: "module aux when opcode",
+			body: [][]byte{{rdbOpModuleAux}, moduleID, {rdbModuleOpString, 0x02}, moduleValue},
+			err:  "at offset 9: invalid module aux when opcode 5",
+		},
+		{
+			name: "module value opcode",
+			body: [][]byte{{rdbTypeModule2}, rdbString("m"), moduleID, {0x06}},
+			err:  "at offset 9: invalid module value opcode 6",
+		},
+	}
+
+	for _, tt := range tests {
+		var s Stats
+		err := analyzeRDB(bytes.NewReader(rdbFile(tt.body...)), &s)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, expected %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -1,83 +1,54 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
+	"html"
 	"io"
 	"math"
-	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/ajstarks/svgo"
 )
 
-func generateSVGHandler(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "image/svg+xml")
-
-	var buf bytes.Buffer
-	if err := generateSVG(&buf); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		io.WriteString(w, err.Error())
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	io.Copy(w, &buf)
-}
-
 const (
-	width  = 1200
-	height = 900
-	top    = 30
-	left   = 30
+	defaultWidth   = 1200
+	defaultColumns = 2
+
+	left = 30 // margin around the panels
 
 	insideTextPadding = 10
 	insidePiePadding  = 10
 
-	globalStatsRectHeight  = 100
-	globalStatsRowHeight   = 50
-	globalStatsColumnWidth = (width - left*2 - insideTextPadding*2) / 4
-
-	nbColumns = 2
-	nbRows    = 1
+	globalStatsRectHeight = 190
+	globalStatsRowHeight  = 50
 
 	rowMargin     = 10
 	columnSpacing = 30
-	columnWidth   = (width - left*2 - columnSpacing*(nbColumns-1)) / nbColumns
-	columnHeight  = (height - top*2 - globalStatsRectHeight - rowMargin*nbRows) / nbRows
 
 	legendHeight       = 40
 	legendPadding      = 5
-	legendWidth        = columnWidth - insidePiePadding*2
 	legendCircleRadius = (legendHeight - legendPadding*2) / 2
-	legendColumnWidth  = (legendWidth - legendPadding*2) / 5
 
 	titleHeight = 50
 
+	minPieSlice         = 2.0 // percent, the smaller slices are grouped
+	pieLabelWidth       = 80
+	pieLabelHeight      = 24
+	pieLabelMaxDistance = 1.3 // times the radius
+
 	fontSize = 16
 )
 
-var colors = [...]string{
-	"FF0000", "00FF00", "0000FF", "FFFF00", "FF00FF", "00FFFF", "000000",
-	"800000", "008000", "000080", "808000", "800080", "008080", "808080",
-	"C00000", "00C000", "0000C0", "C0C000", "C000C0", "00C0C0", "C0C0C0",
-	"400000", "004000", "000040", "404000", "400040", "004040", "404040",
-	"200000", "002000", "000020", "202000", "200020", "002020", "202020",
-	"600000", "006000", "000060", "606000", "600060", "006060", "606060",
-	"A00000", "00A000", "0000A0", "A0A000", "A000A0", "00A0A0", "A0A0A0",
-	"E00000", "00E000", "0000E0", "E0E000", "E000E0", "00E0E0", "E0E0E0",
-}
-
 func toRadians(a float64) float64 {
 	return math.Pi * a / 180
 }
 
-func xPosInCircle(radius int, theta float64) int {
-	return int(float64(radius) * math.Cos(toRadians(theta)))
-}
-
-func yPosInCircle(radius int, theta float64) int {
-	return int(float64(radius) * math.Sin(toRadians(theta)))
+// posInCircle returns the position of the point at the angle theta, in
+// degrees, on the circle of center cx, cy.
+func posInCircle(cx, cy, radius, theta float64) (float64, float64) {
+	return cx + radius*math.Cos(toRadians(theta)), cy + radius*math.Sin(toRadians(theta))
 }
 
 type pieSlice struct {
@@ -86,65 +57,152 @@ type pieSlice struct {
 	color string
 }
 
-func renderPiechart(canvas *svg.SVG, title string, x, y int, slices []pieSlice) {
-	x1 := x + (columnWidth-insidePiePadding*2-60)/2 // 60 is a guesstimate of the width of the bounding box for the title. Don't know how to get it right now.
-	y1 := y + insidePiePadding + titleHeight
-	canvas.Text(x1, y1, title, "fill:white")
+// groupSmallSlices merges the slices of less than minPieSlice percent in a
+// single "other" slice, and drops the empty ones and the undefined ones, like
+// the proportions of a file without keys.
+func groupSmallSlices(slices []pieSlice) []pieSlice {
+	var (
+		res   []pieSlice
+		small []pieSlice
+	)
+	for _, p := range slices {
+		switch {
+		case p.value <= 0 || math.IsNaN(p.value):
+			continue
+		case p.value < minPieSlice:
+			small = append(small, p)
+		default:
+			res = append(res, p)
+		}
+	}
+
+	// A single small slice is kept as is, "other" would only hide its name
+	if len(small) == 1 {
+		return append(res, small[0])
+	}
+	if len(small) > 1 {
+		other := pieSlice{name: "other", color: reportTheme().other}
+		for _, p := range small {
+			other.value += p.value
+		}
+		res = append(res, other)
+	}
+
+	return res
+}
+
+// pieLabel is the percentage written on a slice.
+type pieLabel struct {
+	x, y  float64
+	text  string
+	angle float64 // of the middle of the slice
+	outer bool    // true if the label is outside of the pie
+}
 
-	x = x + columnWidth/2
-	y = y + (columnHeight-legendHeight)/2
+func (l pieLabel) overlaps(o pieLabel) bool {
+	return math.Abs(l.x-o.x) < pieLabelWidth && math.Abs(l.y-o.y) < pieLabelHeight
+}
 
-	radius := (columnWidth - legendHeight - insidePiePadding*3) / 2
+// placePieLabels places each label at the middle of its slice, moving it away
+// from the center while it overlaps a label already placed.
+func placePieLabels(cx, cy, radius float64, labels []pieLabel) {
+	for i := range labels {
+		l := &labels[i]
 
-	var (
-		startAngle = 0.0
-		endAngle   = 0.0
-	)
+		// The label of the only slice of a full pie is at its center
+		start := radius * 0.65
+		if len(labels) == 1 {
+			start = 0
+		}
 
-	textTooltips := make([]struct {
-		x     int
-		y     int
-		value float64
-	}, len(slices))
+		for dist := start; ; dist += 4 {
+			l.x, l.y = posInCircle(cx, cy, dist, l.angle)
+			l.outer = dist > radius
 
-	for i, p := range slices {
-		if p.value <= 0.0 {
-			continue
+			var overlaps bool
+			for _, o := range labels[:i] {
+				overlaps = overlaps || l.overlaps(o)
+			}
+			if !overlaps || dist >= radius*pieLabelMaxDistance {
+				break
+			}
 		}
+	}
+}
 
-		style := fmt.Sprintf("fill:#%s", p.color)
+func renderPiechart(canvas *svg.SVG, title string, b box, slices []pieSlice) {
+	th := reportTheme()
+
+	canvas.Text(b.x+b.w/2, b.y+insidePiePadding+titleHeight, title, "text-anchor:middle")
 
-		if p.value >= 100.0 {
-			canvas.Circle(x, y, radius, style)
+	cx := float64(b.x) + float64(b.w)/2
+	cy := float64(b.y) + float64(b.h-legendHeight)/2
+
+	// Leave room around the pie for the labels moved outside of it
+	radius := float64(b.w-legendHeight-insidePiePadding*3) / 2
+	if max := float64(b.h-titleHeight-legendHeight-insidePiePadding*4) / 2; radius > max {
+		radius = max
+	}
+	radius /= pieLabelMaxDistance
+
+	var (
+		labels []pieLabel
+		angle  float64
+	)
+	for _, p := range slices {
+		if p.value <= 0 {
+			continue
 		}
 
-		startAngle = endAngle
-		endAngle = startAngle + (p.value * 360 / 100)
+		style := fmt.Sprintf("fill:#%s", p.color)
+		sweep := p.value * 360 / 100
 
-		x1 := x + xPosInCircle(radius, startAngle)
-		y1 := y + yPosInCircle(radius, startAngle)
-		x2 := x + xPosInCircle(radius, endAngle)
-		y2 := y + yPosInCircle(radius, endAngle)
+		if sweep >= 360 {
+			canvas.Circle(int(cx), int(cy), int(radius), style)
+		} else {
+			largeArc := 0
+			if sweep > 180 {
+				largeArc = 1
+			}
 
-		canvas.Path(fmt.Sprintf("M%d,%d L%d,%d A%d,%d 0 0,1 %d,%d z", x, y, x1, y1, radius, radius, x2, y2), style)
+			x1, y1 := posInCircle(cx, cy, radius, angle)
+			x2, y2 := posInCircle(cx, cy, radius, angle+sweep)
+			canvas.Path(fmt.Sprintf("M%.2f,%.2f L%.2f,%.2f A%.2f,%.2f 0 %d,1 %.2f,%.2f z", cx, cy, x1, y1, radius, radius, largeArc, x2, y2), style)
+		}
 
-		textTooltips[i].x = x1
-		textTooltips[i].y = y1
-		textTooltips[i].value = p.value
+		labels = append(labels, pieLabel{
+			text:  fmt.Sprintf("%0.2f%%", p.value),
+			angle: angle + sweep/2,
+		})
+		angle += sweep
 	}
 
-	for _, t := range textTooltips {
-		canvas.Text(t.x, t.y, fmt.Sprintf("%0.2f%%", t.value), "fill:white;font-size:20pt;stroke:black;stroke-width:1px")
+	placePieLabels(cx, cy, radius, labels)
+
+	for _, l := range labels {
+		if l.outer {
+			x, y := posInCircle(cx, cy, radius, l.angle)
+			canvas.Line(int(x), int(y), int(l.x), int(l.y), th.axisStyle())
+		}
+		canvas.Text(int(l.x), int(l.y)+pieLabelHeight/3, l.text, fmt.Sprintf("fill:#%s;font-size:16pt;stroke:#%s;stroke-width:1px;text-anchor:middle", th.text, th.panel))
 	}
 }
 
-func renderPiechartLegend(canvas *svg.SVG, x, y int, slices []pieSlice) {
-	canvas.Gstyle("font-size:10pt;fill:black")
-	canvas.Rect(x, y, legendWidth, legendHeight, "fill:white")
+func renderPiechartLegend(canvas *svg.SVG, x, y, width int, slices []pieSlice) {
+	columns := 5
+	if len(slices) > columns {
+		columns = len(slices)
+	}
+	columnWidth := (width - legendPadding*2) / columns
+
+	th := reportTheme()
+
+	canvas.Gstyle("font-size:10pt;fill:#" + th.legendText)
+	canvas.Rect(x, y, width, legendHeight, "fill:#"+th.legend)
 
 	y1 := y + legendCircleRadius + legendPadding
 	for i, p := range slices {
-		x1 := x + legendCircleRadius + legendPadding + (i * legendColumnWidth)
+		x1 := x + legendCircleRadius + legendPadding + (i * columnWidth)
 
 		canvas.Circle(x1, y1, legendCircleRadius, fmt.Sprintf("fill:#%s", p.color))
 		canvas.Text(x1+legendCircleRadius+legendPadding, y1, p.name)
@@ -153,111 +211,861 @@ func renderPiechartLegend(canvas *svg.SVG, x, y int, slices []pieSlice) {
 	canvas.Gend()
 }
 
-func generateSVG(w io.Writer) error {
-	canvas := svg.New(w)
-	canvas.Start(width, height)
-	canvas.Title("RDB statistics")
-	canvas.Rect(0, 0, width, height, "fill:none;stroke:black;stroke-width:3") // global back rectangle
+// piePanel returns a panel of a single column with a pie chart and its legend.
+func piePanel(name, title string, slices func(s *Stats) []pieSlice) panel {
+	return panel{
+		name:    name,
+		title:   title,
+		columns: 1,
+		height:  func(w int) int { return w * 4 / 3 },
+		render: func(canvas *svg.SVG, s *Stats, b box) {
+			renderPanelBackground(canvas, b)
 
-	// Global statistics
-	//  - top row that spans all document
-	//  - 100 high
-	x := left
-	y := top
-	canvas.Rect(x, y, width-left*2, globalStatsRectHeight, "fill:black") // account for the margins
+			pie := groupSmallSlices(slices(s))
+			if len(pie) == 0 {
+				canvas.Text(b.x+b.w/2, b.y+insidePiePadding+titleHeight, title, "text-anchor:middle")
+				canvas.Text(b.x+b.w/2, b.y+b.h/2, "no data", "text-anchor:middle;fill:#"+reportTheme().neutral)
+				return
+			}
+			renderPiechart(canvas, title, b, pie)
 
-	canvas.Gstyle(fmt.Sprintf("font-family:Calibri,sans-serif;font-size:%dpt;fill:white", fontSize))
+			// Legend
 
-	// First row
-	x = left + insideTextPadding
-	y = top + insideTextPadding + fontSize
-	canvas.Text(x, y, fmt.Sprintf("Databases: %d", stats.Database.Count))
-	canvas.Text(x+globalStatsColumnWidth, y, fmt.Sprintf("Keys: %d", stats.Keys.Count))
-	canvas.Text(x+globalStatsColumnWidth*2, y, fmt.Sprintf("Strings: %d", stats.Strings.Count))
+			x := b.x + insidePiePadding
+			y := b.y + b.h - legendHeight - insidePiePadding
+			renderPiechartLegend(canvas, x, y, b.w-insidePiePadding*2, pie)
+		},
+		describe: func(s *Stats) string {
+			var parts []string
+			for _, p := range groupSmallSlices(slices(s)) {
+				parts = append(parts, fmt.Sprintf("%s %.2f%%", p.name, p.value))
+			}
+			if len(parts) == 0 {
+				return fmt.Sprintf("Pie chart of the %s: no data.", title)
+			}
+			return fmt.Sprintf("Pie chart of the %s: %s.", title, strings.Join(parts, ", "))
+		},
+	}
+}
 
-	// Second row
-	x = left + insideTextPadding
-	y = top + insideTextPadding + fontSize + globalStatsRowHeight + insideTextPadding
-	canvas.Text(x, y, fmt.Sprintf("Lists: %d", stats.Lists.Count))
-	canvas.Text(x+globalStatsColumnWidth, y, fmt.Sprintf("Sets: %d", stats.Sets.Count))
-	canvas.Text(x+globalStatsColumnWidth*2, y, fmt.Sprintf("Hashes: %d", stats.Hashes.Count))
-	canvas.Text(x+globalStatsColumnWidth*3, y, fmt.Sprintf("Sorted Sets: %d", stats.SortedSets.Count))
+// panels lists the panels of the report, in the order they are laid out.
+var panels = []panel{
+	{
+		name:     "summary",
+		title:    "global statistics",
+		height:   fixedHeight(globalStatsRectHeight),
+		render:   renderSummaryPanel,
+		describe: describeSummary,
+	},
+	piePanel("keys", "keys status", keysStatusSlices),
+	piePanel("space", "space usage", spaceUsageSlices),
+	{
+		name:      "sizes",
+		title:     "keys per size",
+		columns:   1,
+		height:    func(w int) int { return w * 3 / 4 },
+		render:    renderSizesPanel,
+		describe:  describeSizes,
+		available: func(s *Stats) bool { return len(s.SizeHistogram) > 0 },
+	},
+	{
+		name:      "cumulative",
+		title:     "cumulative distribution of the key sizes",
+		columns:   1,
+		height:    func(w int) int { return w * 3 / 4 },
+		render:    renderCumulativePanel,
+		describe:  describeCumulative,
+		available: func(s *Stats) bool { return len(s.SizeHistogram) > 0 },
+	},
+	{
+		name:      "prefixes",
+		title:     "biggest prefixes",
+		render:    renderPrefixesPanel,
+		describe:  describePrefixes,
+		available: func(s *Stats) bool { return s.Prefixes != nil },
+	},
+	{
+		name:      "nottl",
+		title:     "keys without TTL",
+		render:    renderNoTTLPanel,
+		describe:  describeNoTTL,
+		available: func(s *Stats) bool { return s.NoTTL != nil },
+	},
+	{
+		name:      "encodings",
+		title:     "encodings",
+		render:    renderEncodingsPanel,
+		describe:  describeEncodings,
+		available: func(s *Stats) bool { return s.Encodings != nil },
+	},
+	{
+		name:      "advisor",
+		title:     "encoding thresholds advisor",
+		render:    renderAdvisorPanel,
+		describe:  describeAdvisor,
+		available: func(s *Stats) bool { return s.Advisor != nil },
+	},
+	{
+		name:      "stringprofile",
+		title:     "string values",
+		render:    renderStringProfilePanel,
+		describe:  describeStringProfile,
+		available: func(s *Stats) bool { return s.StringProfile != nil },
+	},
+	{
+		name:      "compression",
+		title:     "compression",
+		render:    renderCompressionPanel,
+		describe:  describeCompression,
+		available: func(s *Stats) bool { return s.Compression != nil },
+	},
+	{
+		name:      "duplicates",
+		title:     "duplicated values",
+		render:    renderDuplicatesPanel,
+		describe:  describeDuplicates,
+		available: func(s *Stats) bool { return s.Duplicates != nil },
+	},
+	{
+		name:      "distinct",
+		title:     "distinct elements",
+		render:    renderDistinctPanel,
+		describe:  describeDistinct,
+		available: func(s *Stats) bool { return s.Distinct != nil },
+	},
+	{
+		name:      "hashfields",
+		title:     "hash fields",
+		render:    renderHashFieldsPanel,
+		describe:  describeHashFields,
+		available: func(s *Stats) bool { return s.HashFields != nil },
+	},
+}
+
+func panelNames() []string {
+	var res []string
+	for _, p := range panels {
+		res = append(res, p.name)
+	}
+	return res
+}
+
+func findPanel(name string) (panel, bool) {
+	for _, p := range panels {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return panel{}, false
+}
+
+// selectedPanels returns the panels selected with -charts, all of them if the
+// selection is empty.
+func selectedPanels() ([]panel, error) {
+	names := splitList(flCharts)
+	if len(names) == 0 {
+		return panels, nil
+	}
+
+	var res []panel
+	for _, name := range names {
+		p, ok := findPanel(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown chart %q", name)
+		}
+		res = append(res, p)
+	}
+
+	return res, nil
+}
+
+// reportLayout returns the layout of the report, sized with -width and -columns.
+func reportLayout() gridLayout {
+	return newGridLayout(flSVGWidth, flSVGColumns)
+}
+
+func keysStatusSlices(s *Stats) []pieSlice {
+	expired := s.Keys.ExpiredProportion()
+	expiring := s.Keys.ExpiringProportion()
 
-	//
-	// Details: first row
-	//
+	th := reportTheme()
 
-	// First column - keys
+	return []pieSlice{
+		{"expired", expired, th.alert},
+		{"expiring", expiring, th.caution},
+		{"normal", 100.0 - expired - expiring, th.neutral},
+	}
+}
+
+func spaceUsageSlices(s *Stats) []pieSlice {
+	sup := s.SpaceUsage()
+
+	th := reportTheme()
+
+	res := []pieSlice{
+		{"strings", sup.Strings, th.types[stringType]},
+		{"lists", sup.Lists, th.types[listType]},
+		{"sets", sup.Sets, th.types[setType]},
+		{"hashes", sup.Hashes, th.types[hashType]},
+		{"zsets", sup.SortedSets, th.types[sortedSetType]},
+	}
+
+	// Only in the files written by the newer versions of Redis
+	if s.Streams.Count > 0 {
+		res = append(res, pieSlice{"streams", sup.Streams, th.types[streamType]})
+	}
+	if s.Modules.Count > 0 {
+		res = append(res, pieSlice{"modules", sup.Modules, th.types[moduleType]})
+	}
 
-	x = left
-	y = top + globalStatsRectHeight + rowMargin
+	return res
+}
+
+// renderSummaryPanel renders the global statistics on two rows of four columns,
+// then the header of the RDB file.
+func renderSummaryPanel(canvas *svg.SVG, s *Stats, b box) {
+	renderPanelBackground(canvas, b)
+
+	columnWidth := (b.w - insideTextPadding*2) / 4
+
+	// First row
+	x := b.x + insideTextPadding
+	y := b.y + insideTextPadding + fontSize
+	canvas.Text(x, y, fmt.Sprintf("Databases: %d", s.Database.Count))
+	canvas.Text(x+columnWidth, y, fmt.Sprintf("Keys: %d", s.Keys.Count))
+	canvas.Text(x+columnWidth*2, y, fmt.Sprintf("Strings: %d", s.Strings.Count))
+	canvas.Text(x+columnWidth*3, y, fmt.Sprintf("Streams: %d, modules: %d", s.Streams.Count, s.Modules.Count))
 
-	canvas.Rect(x, y, columnWidth, columnHeight, "fill:black")
+	// Second row
+	y = b.y + insideTextPadding + fontSize + globalStatsRowHeight + insideTextPadding
+	canvas.Text(x, y, fmt.Sprintf("Lists: %d", s.Lists.Count))
+	canvas.Text(x+columnWidth, y, fmt.Sprintf("Sets: %d", s.Sets.Count))
+	canvas.Text(x+columnWidth*2, y, fmt.Sprintf("Hashes: %d", s.Hashes.Count))
+	canvas.Text(x+columnWidth*3, y, fmt.Sprintf("Sorted Sets: %d", s.SortedSets.Count))
+
+	// RDB header
+	y += globalStatsRowHeight + insideTextPadding
+
+	info := s.RDB
+	if info == nil {
+		canvas.Text(x, y, "RDB header: unknown")
+		return
+	}
 
-	expired := stats.Keys.ExpiredProportion()
-	expiring := stats.Keys.ExpiringProportion()
-	pie := []pieSlice{
-		{"expired", expired, colors[0]},
-		{"expiring", expiring, colors[1]},
-		{"normal", 100.0 - expired - expiring, colors[2]},
+	// Smaller, the values are longer than the counts above
+	cell := func(column int, text string) {
+		canvas.Text(x+columnWidth*column, y, truncate(text, columnWidth/8), fmt.Sprintf("font-size:%dpt", chartFontSize+2))
 	}
 
-	renderPiechart(canvas, "keys status", x, y, pie)
+	version := fmt.Sprintf("RDB v%d", info.Version)
+	if v := info.RedisVersion(); v != "" {
+		version = fmt.Sprintf("Redis %s, %s", v, version)
+	}
+	cell(0, version)
+	if t := info.CreationTime(); !t.IsZero() {
+		cell(1, "Created: "+t.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	if used := info.UsedMemory(); used > 0 {
+		cell(2, "Used memory: "+humanBytes(used))
+		cell(3, fmt.Sprintf("Estimated: %s (%.0f%%)", humanBytes(s.TotalByteSize()), percent(s.TotalByteSize(), used)))
+	}
 
-	// Legend
+	y += insideTextPadding*2 + chartFontSize
+	canvas.Text(x, y, truncate(rdbHeaderLine(info), (b.w-insideTextPadding*2)/7), fmt.Sprintf("font-size:%dpt", chartFontSize))
+}
 
-	x = left + insidePiePadding
-	y = top + globalStatsRectHeight + rowMargin + columnHeight - legendHeight - insidePiePadding
-	renderPiechartLegend(canvas, x, y, pie)
+// rdbHeaderLine returns the AUX fields and the RESIZEDB hints of info on a line.
+func rdbHeaderLine(info *RDBInfo) string {
+	var parts []string
+	for _, name := range info.AuxNames() {
+		parts = append(parts, name+"="+info.Aux[name])
+	}
+	for _, h := range info.Resize {
+		parts = append(parts, fmt.Sprintf("db %d: %d keys, %d with expiry", h.DB, h.Keys, h.Expiring))
+	}
+	return strings.Join(parts, ", ")
+}
 
-	// Second column - space usage
+func describeSummary(s *Stats) string {
+	res := fmt.Sprintf("%d databases, %d keys: %d strings, %d lists, %d sets, %d hashes, %d sorted sets, %d streams and %d module keys.",
+		s.Database.Count, s.Keys.Count, s.Strings.Count, s.Lists.Count, s.Sets.Count, s.Hashes.Count, s.SortedSets.Count, s.Streams.Count, s.Modules.Count)
+	if s.RDB != nil {
+		res += fmt.Sprintf(" RDB version %d.", s.RDB.Version)
+		if line := rdbHeaderLine(s.RDB); line != "" {
+			res += " " + line + "."
+		}
+	}
+	return res
+}
 
-	x = left + columnWidth + columnSpacing
-	y = top + globalStatsRectHeight + rowMargin
-	canvas.Rect(x, y, columnWidth, columnHeight, "fill:black")
+// svgAttr returns the attribute name with the escaped value.
+func svgAttr(name, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, html.EscapeString(value))
+}
 
-	sup := stats.SpaceUsage()
-	pie = []pieSlice{
-		{"strings", sup.Strings, colors[0]},
-		{"lists", sup.Lists, colors[1]},
-		{"sets", sup.Sets, colors[2]},
-		{"hashes", sup.Hashes, colors[3]},
-		{"zsets", sup.SortedSets, colors[4]},
+// renderPanels renders the panels of s laid out with l in a new SVG document.
+func renderPanels(w io.Writer, title string, l gridLayout, selected []panel, s *Stats) {
+	var available []panel
+	for _, p := range selected {
+		if p.isAvailable(s) {
+			available = append(available, p)
+		}
 	}
 
-	renderPiechart(canvas, "space usage", x, y, pie)
+	placed, height := l.place(available)
 
-	// Legend
+	th := reportTheme()
 
-	x = left + columnWidth + columnSpacing + insidePiePadding
-	y = top + globalStatsRectHeight + rowMargin + columnHeight - legendHeight - insidePiePadding
-	renderPiechartLegend(canvas, x, y, pie)
+	canvas := svg.New(w)
+	canvas.Start(l.width, height, `role="graphics-document"`, svgAttr("aria-label", title))
+	canvas.Title(title)
+	canvas.Desc(fmt.Sprintf("%d charts of the statistics of a Redis RDB file.", len(placed)))
+	canvas.Rect(0, 0, l.width, height, "fill:#"+th.page) // global back rectangle
 
+	canvas.Gstyle(fmt.Sprintf("font-family:Calibri,sans-serif;font-size:%dpt;fill:#%s", fontSize, th.text))
+	for _, p := range placed {
+		// Each panel is an image for the screen readers, described by its
+		// title and its text alternative
+		canvas.Group(`role="img"`, svgAttr("aria-label", p.title))
+		canvas.Title(p.title)
+		canvas.Desc(p.description(s))
+		p.render(canvas, s, p.box)
+		canvas.Gend()
+	}
 	canvas.Gend()
+
 	canvas.End()
+}
+
+func generateSVG(w io.Writer, s *Stats) error {
+	selected, err := selectedPanels()
+	if err != nil {
+		return err
+	}
+	if err := checkLayout(flSVGWidth, flSVGColumns); err != nil {
+		return err
+	}
+
+	renderPanels(w, "RDB statistics", reportLayout(), selected, s)
 
 	return nil
 }
 
-func renderStats() error {
-	switch {
-	case flSVGOutput != "":
-		output, err := os.Create(flSVGOutput)
-		if err != nil {
-			return fmt.Errorf("unable to create SVG output file. err=%v", err)
+// renderNoTTLPanel renders the namespaces with the most bytes without TTL.
+//
+// Each namespace has a bar split between the bytes without and with a TTL; the
+// namespaces where the majority of the keys never expire are highlighted.
+func renderNoTTLPanel(canvas *svg.SVG, s *Stats, b box) {
+	st := s.NoTTL
+	th := reportTheme()
+
+	var rows []stackedBar
+	for _, ns := range st.Namespaces {
+		if ns.NoTTLKeys == 0 {
+			break
+		}
+
+		r := stackedBar{
+			label: ns.Name,
+			parts: []barPart{
+				{float64(ns.NoTTLBytes), th.alert},
+				{float64(ns.Bytes - ns.NoTTLBytes), th.neutral},
+			},
+			info: fmt.Sprintf("%.0f%% of %d keys without TTL, %s", percent(ns.NoTTLKeys, ns.Keys), ns.Keys, humanBytes(ns.NoTTLBytes)),
+		}
+		if ns.Leaking() {
+			r.highlight = th.alert
 		}
+		rows = append(rows, r)
+	}
+
+	renderStackedBarPanel(canvas, b, fmt.Sprintf("keys without TTL: %d keys, %s (highlighted: most keys of the namespace never expire)", st.Keys, humanBytes(st.Bytes)), rows)
+}
 
-		fmt.Println("generating SVG file...")
+func describeNoTTL(s *Stats) string {
+	st := s.NoTTL
 
-		if err = generateSVG(output); err != nil {
-			return fmt.Errorf("unable to generate SVG. err=%v", err)
+	var (
+		parts   []string
+		leaking int
+	)
+	for _, ns := range st.Namespaces {
+		if ns.Leaking() {
+			leaking++
 		}
-	case flListenAddr != "":
-		http.HandleFunc("/", generateSVGHandler)
-		if err := http.ListenAndServe(flListenAddr, nil); err != nil {
-			return fmt.Errorf("unable to listen on %s. err=%v", flListenAddr, err)
+		if len(parts) < 3 && ns.NoTTLKeys > 0 {
+			parts = append(parts, fmt.Sprintf("%s (%s in %d keys)", ns.Name, humanBytes(ns.NoTTLBytes), ns.NoTTLKeys))
+		}
+	}
+
+	res := fmt.Sprintf("%d keys without TTL use %s.", st.Keys, humanBytes(st.Bytes))
+	if len(parts) > 0 {
+		res += fmt.Sprintf(" The namespaces with the most bytes without TTL are %s; in %d namespaces most keys never expire.", strings.Join(parts, ", "), leaking)
+	}
+	return res
+}
+
+// renderEncodingsPanel renders the bytes per type and encoding.
+//
+// The full encodings of the types which have a compact one are highlighted
+// when some of their keys are just above a threshold.
+func renderEncodingsPanel(canvas *svg.SVG, s *Stats, b box) {
+	e := s.Encodings
+	th := reportTheme()
+
+	var near int
+	for _, n := range e.NearThresholds {
+		near += n.Keys
+	}
+
+	highlighted := make(map[string]bool)
+	for _, n := range e.NearThresholds {
+		if n.Keys > 0 {
+			highlighted[n.Type+" "+n.Encoding] = true
+		}
+	}
+
+	var rows []stackedBar
+	for _, te := range e.Encodings {
+		label := te.Type + " " + te.Encoding
+		r := stackedBar{
+			label: label,
+			parts: []barPart{{float64(te.Bytes), th.types[te.Type]}},
+			info:  fmt.Sprintf("%d keys, %s", te.Keys, humanBytes(te.Bytes)),
+		}
+		if highlighted[label] {
+			r.highlight = th.alert
+		}
+		rows = append(rows, r)
+	}
+
+	renderStackedBarPanel(canvas, b, fmt.Sprintf("encodings (highlighted: %d keys up to %.0f%% above a threshold of the compact encoding)", near, e.Margin*100), rows)
+}
+
+func describeEncodings(s *Stats) string {
+	e := s.Encodings
+
+	var parts []string
+	for _, te := range e.Encodings {
+		parts = append(parts, fmt.Sprintf("%s %s (%d keys, %s)", te.Type, te.Encoding, te.Keys, humanBytes(te.Bytes)))
+	}
+	res := fmt.Sprintf("Bytes per type and encoding: %s.", strings.Join(parts, ", "))
+
+	parts = nil
+	for _, n := range e.NearThresholds {
+		if n.Keys > 0 {
+			parts = append(parts, fmt.Sprintf("%d keys above %s %d", n.Keys, n.Setting, n.Limit))
 		}
 	}
+	if len(parts) > 0 {
+		res += fmt.Sprintf(" Up to %.0f%% above the thresholds: %s.", e.Margin*100, strings.Join(parts, ", "))
+	}
+	if n := e.UnknownKeys(); n > 0 {
+		res += fmt.Sprintf(" The encoding of %d keys, read from a file up to RDB version 6, is unknown: they are not compared to the thresholds.", n)
+	}
+	return res
+}
+
+// renderAdvisorPanel renders, for each encoding threshold, the value saving
+// the most memory and the collections in a compact encoding with it.
+func renderAdvisorPanel(canvas *svg.SVG, s *Stats, b box) {
+	th := reportTheme()
+
+	var rows []stackedBar
+	for _, a := range s.Advisor.Settings {
+		if a.Keys == 0 {
+			continue
+		}
+
+		best := a.Best()
+		r := stackedBar{
+			label: fmt.Sprintf("%s %d", a.Setting, best.Value),
+			parts: []barPart{
+				{float64(best.CompactKeys), th.types[a.Type]},
+				{float64(a.Keys - best.CompactKeys), th.neutral},
+			},
+			info: fmt.Sprintf("%.0f%% compact, saves %s", percent(best.CompactKeys, a.Keys), signedBytes(best.SavedBytes)),
+		}
+		if best.Value != a.Current {
+			r.highlight = th.alert
+		}
+		rows = append(rows, r)
+	}
+
+	renderStackedBarPanel(canvas, b, "encoding thresholds advisor: the value of each setting saving the most memory (highlighted: not the current value)", rows)
+}
+
+func describeAdvisor(s *Stats) string {
+	var parts []string
+	for _, a := range s.Advisor.Settings {
+		if best := a.Best(); a.Keys > 0 && best.Value != a.Current {
+			parts = append(parts, fmt.Sprintf("%s %d instead of %d saves %s, %.0f%% of the collections being compact", a.Setting, best.Value, a.Current, humanBytes(best.SavedBytes), percent(best.CompactKeys, a.Keys)))
+		}
+	}
+	if len(parts) == 0 {
+		return "The current encoding thresholds use the least memory."
+	}
+	return fmt.Sprintf("Estimated memory saved by changing the encoding thresholds: %s.", strings.Join(parts, "; "))
+}
+
+// renderStringProfilePanel renders the bytes of the string values per class.
+func renderStringProfilePanel(canvas *svg.SVG, s *Stats, b box) {
+	th := reportTheme()
+
+	var bars []bar
+	for _, vc := range s.StringProfile.Classes {
+		bars = append(bars, bar{label: vc.Class, value: float64(vc.Bytes), color: th.types[stringType]})
+	}
+
+	renderBarPanel(canvas, b, "string values per class", bars, func(v float64) string {
+		return fmt.Sprintf("%s, %.2f%%", humanBytes(int(v)), percent(int(v), s.Strings.TotalByteSize))
+	})
+}
+
+func describeStringProfile(s *Stats) string {
+	var parts []string
+	for _, vc := range s.StringProfile.Classes {
+		parts = append(parts, fmt.Sprintf("%s (%d keys, %s)", vc.Class, vc.Keys, humanBytes(vc.Bytes)))
+	}
+	return fmt.Sprintf("Bytes of the string values per class: %s.", strings.Join(parts, ", "))
+}
+
+// renderCompressionPanel renders the estimated size of the values once
+// compressed with each codec.
+func renderCompressionPanel(canvas *svg.SVG, s *Stats, b box) {
+	th := reportTheme()
+
+	total := s.Compression.Total()
+
+	var rows []stackedBar
+	for _, e := range total.Codecs {
+		saved := math.Max(0, float64(e.SavedBytes))
+		rows = append(rows, stackedBar{
+			label: e.Codec,
+			parts: []barPart{
+				{float64(total.Bytes) - saved, th.series[0]},
+				{saved, th.neutral},
+			},
+			info: fmt.Sprintf("ratio %.2f, saves %s", e.Ratio, signedBytes(e.SavedBytes)),
+		})
+	}
+
+	renderStackedBarPanel(canvas, b, fmt.Sprintf("estimated compression of the values (%.2f%% sampled)", 100*s.Compression.Sample), rows)
+}
+
+func describeCompression(s *Stats) string {
+	total := s.Compression.Total()
+	if total.SampledValues == 0 {
+		return "No value was sampled to estimate the compression."
+	}
+
+	var parts []string
+	for _, e := range total.Codecs {
+		parts = append(parts, fmt.Sprintf("%s ratio %.2f, saving %s", e.Codec, e.Ratio, signedBytes(e.SavedBytes)))
+	}
+	return fmt.Sprintf("Estimated compression of the %s of values from %d sampled values: %s.", humanBytes(total.Bytes), total.SampledValues, strings.Join(parts, "; "))
+}
+
+// renderDuplicatesPanel renders the duplicated values wasting the most bytes,
+// named after one of their keys.
+func renderDuplicatesPanel(canvas *svg.SVG, s *Stats, b box) {
+	d := s.Duplicates
+
+	var rows []stackedBar
+	for _, v := range d.Top {
+		rows = append(rows, stackedBar{
+			label: duplicateLabel(v),
+			parts: []barPart{{float64(v.WastedBytes), reportTheme().types[stringType]}},
+			info:  fmt.Sprintf("%d copies of %s, %s wasted", v.Count, humanBytes(v.Size), humanBytes(v.WastedBytes)),
+		})
+	}
+
+	renderStackedBarPanel(canvas, b, fmt.Sprintf("duplicated values: %s wasted in total", humanBytes(d.WastedBytes)), rows)
+}
+
+// duplicateLabel returns the first key holding v, or its hash if the stats
+// have no key, like the stats files edited by hand.
+func duplicateLabel(v DuplicateValue) string {
+	if len(v.Keys) > 0 {
+		return v.Keys[0]
+	}
+	return "hash " + v.Hash
+}
+
+func describeDuplicates(s *Stats) string {
+	d := s.Duplicates
+	if d.DuplicatedValues == 0 && len(d.Top) == 0 {
+		return "No string value is stored in several keys."
+	}
+
+	res := fmt.Sprintf("%d string values are stored in %d keys, wasting %s.", d.DuplicatedValues, d.DuplicateKeys, humanBytes(d.WastedBytes))
+	if !d.Exact {
+		res = "About " + res
+	}
+	if len(d.Top) > 0 {
+		v := d.Top[0]
+		example := "with the hash " + v.Hash
+		if len(v.Keys) > 0 {
+			example = fmt.Sprintf("like %q", v.Keys[0])
+		}
+		res += fmt.Sprintf(" The most duplicated value is a %s %s value in %d keys %s.", humanBytes(v.Size), v.Class, v.Count, example)
+	}
+	return res
+}
+
+// renderDistinctPanel renders the elements of the prefixes with the most
+// elements, the distinct ones in the color of the type.
+func renderDistinctPanel(canvas *svg.SVG, s *Stats, b box) {
+	th := reportTheme()
+
+	var rows []stackedBar
+	for _, g := range s.Distinct.Prefixes {
+		distinct := math.Min(float64(g.Distinct), float64(g.Elements))
+		rows = append(rows, stackedBar{
+			label: fmt.Sprintf("%s %s", g.Prefix, g.Type),
+			parts: []barPart{
+				{distinct, th.types[g.Type]},
+				{float64(g.Elements) - distinct, th.neutral},
+			},
+			info: fmt.Sprintf("%s/%s distinct %s", humanCount(float64(g.Distinct)), humanCount(float64(g.Elements)), g.Element),
+		})
+	}
+
+	renderStackedBarPanel(canvas, b, "distinct elements of the biggest prefixes (estimated, grey: repeated in several keys)", rows)
+}
+
+func describeDistinct(s *Stats) string {
+	var parts []string
+	for _, g := range s.Distinct.Types {
+		parts = append(parts, fmt.Sprintf("%d %ss have about %d distinct %s out of %d", g.Keys, g.Type, g.Distinct, g.Element, g.Elements))
+	}
+	if len(parts) == 0 {
+		return "There is no set, hash or sorted set."
+	}
+	return fmt.Sprintf("Estimated distinct elements: %s.", strings.Join(parts, "; "))
+}
+
+// patternField is a field of the hashes of a pattern.
+type patternField struct {
+	pattern HashPattern
+	field   HashField
+}
+
+// biggestHashFields returns the n fields using the most bytes, all patterns
+// together.
+func biggestHashFields(s *Stats, n int) []patternField {
+	var res []patternField
+	for _, p := range s.HashFields.Patterns {
+		for _, f := range p.Fields {
+			res = append(res, patternField{p, f})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].field.Bytes() > res[j].field.Bytes() })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// renderHashFieldsPanel renders the fields of the hashes using the most bytes,
+// their values in the color of the hashes and their names in grey.
+func renderHashFieldsPanel(canvas *svg.SVG, s *Stats, b box) {
+	th := reportTheme()
+
+	var rows []stackedBar
+	for _, pf := range biggestHashFields(s, panelRows) {
+		rows = append(rows, stackedBar{
+			label: pf.pattern.Pattern + " " + pf.field.Name,
+			parts: []barPart{
+				{float64(pf.field.ValueBytes), th.types[hashType]},
+				{float64(pf.field.NameBytes), th.neutral},
+			},
+			info: fmt.Sprintf("%s, %.0f%% of the hashes", humanBytes(pf.field.Bytes()), percent(pf.field.Keys, pf.pattern.Keys)),
+		})
+	}
+
+	renderStackedBarPanel(canvas, b, "biggest hash fields per key pattern (grey: field names)", rows)
+}
+
+func describeHashFields(s *Stats) string {
+	var parts []string
+	for _, pf := range biggestHashFields(s, 3) {
+		parts = append(parts, fmt.Sprintf("%s in %s (%s, %.2f%% of the bytes of the pattern)", pf.field.Name, pf.pattern.Pattern, humanBytes(pf.field.Bytes()), percent(pf.field.Bytes(), pf.pattern.Bytes)))
+	}
+	if len(parts) == 0 {
+		return "There is no hash."
+	}
+	return fmt.Sprintf("The hash fields using the most bytes are %s.", strings.Join(parts, ", "))
+}
+
+// renderPrefixesPanel renders the top level prefixes using the most bytes.
+func renderPrefixesPanel(canvas *svg.SVG, s *Stats, b box) {
+	total := s.TotalByteSize()
+
+	var bars []bar
+	for _, c := range s.Prefixes.SortedChildren() {
+		bars = append(bars, bar{c.Name, float64(c.Bytes), reportTheme().series[0]})
+	}
+
+	renderBarPanel(canvas, b, "biggest prefixes", bars, func(v float64) string {
+		return fmt.Sprintf("%s, %.2f%%", formatBytes(v), percent(int(v), total))
+	})
+}
+
+func describePrefixes(s *Stats) string {
+	total := s.TotalByteSize()
+
+	var parts []string
+	for i, c := range s.Prefixes.SortedChildren() {
+		if i >= 3 {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s, %.2f%%)", c.Name, humanBytes(c.Bytes), percent(c.Bytes, total)))
+	}
+	if len(parts) == 0 {
+		return "No prefixes."
+	}
+
+	return fmt.Sprintf("Bar chart of the top level prefixes using the most bytes: %s.", strings.Join(parts, ", "))
+}
+
+// renderSizesPanel renders the histogram of the key sizes, on a logarithmic scale.
+func renderSizesPanel(canvas *svg.SVG, s *Stats, b box) {
+	renderPanelBackground(canvas, b)
+	renderPanelTitle(canvas, b, "keys per size")
+
+	var (
+		bins []histogramBin
+		max  float64
+	)
+	for _, bucket := range s.SizeHistogram {
+		bins = append(bins, histogramBin{humanBytes(bucket.MinSize), float64(bucket.Keys)})
+		max = math.Max(max, float64(bucket.Keys))
+	}
+
+	renderHistogram(canvas, b, bins, logAxis(1, max, 10, humanCount), reportTheme().series[0])
+}
+
+func describeSizes(s *Stats) string {
+	var top SizeBucket
+	for _, bucket := range s.SizeHistogram {
+		if bucket.Keys > top.Keys {
+			top = bucket
+		}
+	}
+
+	return fmt.Sprintf("Histogram of the number of keys per size, on a logarithmic scale. The most common sizes are from %s to %s, with %d keys.",
+		humanBytes(top.MinSize), humanBytes(nextBucketSize(top.MinSize)), top.Keys)
+}
+
+// nextBucketSize returns the minimum size of the bucket of the size histogram
+// following the bucket of the minimum size min.
+func nextBucketSize(min int) int {
+	if min == 0 {
+		return 1
+	}
+	return min * 2
+}
+
+// renderCumulativePanel renders the share of the keys and of the bytes held by
+// the keys smaller than a size.
+func renderCumulativePanel(canvas *svg.SVG, s *Stats, b box) {
+	renderPanelBackground(canvas, b)
+	renderPanelTitle(canvas, b, "cumulative distribution of the key sizes")
+
+	var totalKeys, totalBytes int
+	for _, bucket := range s.SizeHistogram {
+		totalKeys += bucket.Keys
+		totalBytes += bucket.Bytes
+	}
+
+	th := reportTheme()
+	keys := lineSeries{name: "keys", color: th.series[0]}
+	bytes := lineSeries{name: "bytes", color: th.series[1]}
+
+	var cumKeys, cumBytes int
+	for i, bucket := range s.SizeHistogram {
+		cumKeys += bucket.Keys
+		cumBytes += bucket.Bytes
+
+		// The bucket holds the keys smaller than the minimum size of the next one
+		upper := float64(uint(1) << uint(i))
+		keys.points = append(keys.points, point{upper, percent(cumKeys, totalKeys)})
+		bytes.points = append(bytes.points, point{upper, percent(cumBytes, totalBytes)})
+	}
+
+	maxSize := float64(uint(1) << uint(len(s.SizeHistogram)))
+	renderLineChart(canvas, b, []lineSeries{keys, bytes}, logAxis(1, maxSize, 16, formatBytes), axis{max: 100, format: formatPercent})
+}
+
+func describeCumulative(s *Stats) string {
+	var totalKeys, totalBytes int
+	for _, bucket := range s.SizeHistogram {
+		totalKeys += bucket.Keys
+		totalBytes += bucket.Bytes
+	}
+
+	var (
+		cumKeys, cumBytes     int
+		halfKeys, halfBytes   int
+		foundKeys, foundBytes bool
+	)
+	for _, bucket := range s.SizeHistogram {
+		cumKeys += bucket.Keys
+		cumBytes += bucket.Bytes
+
+		if !foundKeys && cumKeys*2 >= totalKeys {
+			halfKeys, foundKeys = nextBucketSize(bucket.MinSize), true
+		}
+		if !foundBytes && cumBytes*2 >= totalBytes {
+			halfBytes, foundBytes = nextBucketSize(bucket.MinSize), true
+		}
+	}
+
+	return fmt.Sprintf("Line chart of the share of the keys and of the bytes held by the keys smaller than a size. Half of the keys are smaller than %s, half of the bytes are held by keys smaller than %s.",
+		humanBytes(halfKeys), humanBytes(halfBytes))
+}
+
+// generateChartSVG renders a single panel in its own SVG document, as wide as
+// in the report.
+func generateChartSVG(w io.Writer, p panel, s *Stats) error {
+	if !p.isAvailable(s) {
+		return fmt.Errorf("no data for the chart %q", p.name)
+	}
+	if err := checkLayout(flSVGWidth, flSVGColumns); err != nil {
+		return err
+	}
+
+	l := newGridLayout(reportLayout().spanWidth(p.columns)+left*2, 1)
+	renderPanels(w, p.title, l, []panel{p}, s)
+
+	return nil
+}
+
+// writeSVGFile writes the report to the SVG file filename.
+func writeSVGFile(filename string, s *Stats) error {
+	output, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("unable to create SVG output file. err=%v", err)
+	}
+	defer output.Close()
+
+	fmt.Fprintln(os.Stderr, "generating SVG file...")
+
+	if err = generateSVG(output, s); err != nil {
+		return fmt.Errorf("unable to generate SVG. err=%v", err)
+	}
+
+	return nil
+}
+
+func renderStats(s *Stats) error {
+	switch {
+	case flSVGOutput != "":
+		return writeReportFile(flSVGOutput, s)
+	case flListenAddr != "":
+		return serve(s)
+	}
 
 	return nil
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a policy evaluated against the stats by the check command.
+//
+// A rule applies to all the keys, to the keys of a type and/or to the keys
+// below a prefix. Every limit set is checked, unset limits are ignored.
+type Rule struct {
+	Name   string `yaml:"name"`
+	Prefix string `yaml:"prefix,omitempty"`
+	Type   string `yaml:"type,omitempty"`
+
+	MaxKeys           *int     `yaml:"max-keys,omitempty"`
+	MaxBytes          *int     `yaml:"max-bytes,omitempty"`
+	MaxBytesPercent   *float64 `yaml:"max-bytes-percent,omitempty"` // of the bytes of all the keys
+	MaxNoTTLKeys      *int     `yaml:"max-no-ttl-keys,omitempty"`
+	MaxNoTTLPercent   *float64 `yaml:"max-no-ttl-percent,omitempty"`
+	MaxExpiredPercent *float64 `yaml:"max-expired-percent,omitempty"`
+	MaxKeySize        *int     `yaml:"max-key-size,omitempty"`
+	MaxLength         *int     `yaml:"max-length,omitempty"` // number of elements of a key
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+func (r Rule) validate() error {
+	switch {
+	case r.Name == "":
+		return errors.New("a rule has no name")
+	case r.Type != "" && !isKeyType(r.Type):
+		return fmt.Errorf("rule %q: unknown type %q", r.Name, r.Type)
+	case r.Type != "" && (r.MaxNoTTLKeys != nil || r.MaxNoTTLPercent != nil || r.MaxExpiredPercent != nil):
+		return fmt.Errorf("rule %q: the TTL limits can't be used with a type", r.Name)
+	case r.Type != "" && r.Prefix != "" && r.MaxKeys != nil:
+		return fmt.Errorf("rule %q: max-keys can't be used with both a type and a prefix", r.Name)
+	case r.Type != "" && r.Prefix != "" && r.MaxKeySize != nil:
+		return fmt.Errorf("rule %q: max-key-size can't be used with both a type and a prefix", r.Name)
+	case r.Prefix != "" && r.MaxLength != nil:
+		return fmt.Errorf("rule %q: max-length can't be used with a prefix", r.Name)
+	}
+	return nil
+}
+
+func isKeyType(t string) bool {
+	switch t {
+	case stringType, listType, setType, hashType, sortedSetType, streamType, moduleType:
+		return true
+	default:
+		return false
+	}
+}
+
+func readRules(filename string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rules file '%s'. err=%v", filename, err)
+	}
+
+	var f rulesFile
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to parse rules file '%s'. err=%v", filename, err)
+	}
+
+	for _, r := range f.Rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.Rules, nil
+}
+
+// thresholdRules returns the rules of the threshold flags of the check command.
+func thresholdRules() []Rule {
+	var res []Rule
+
+	if flMaxKeys > 0 {
+		res = append(res, Rule{Name: "max-keys", MaxKeys: &flMaxKeys})
+	}
+	if flMaxBytes > 0 {
+		res = append(res, Rule{Name: "max-bytes", MaxBytes: &flMaxBytes})
+	}
+	if flMaxKeySize > 0 {
+		res = append(res, Rule{Name: "max-key-size", MaxKeySize: &flMaxKeySize})
+	}
+	if flMaxExpiredPercent > 0 {
+		res = append(res, Rule{Name: "max-expired-percent", MaxExpiredPercent: &flMaxExpiredPercent})
+	}
+
+	return res
+}
+
+// ruleScope holds the stats of the keys a rule applies to.
+type ruleScope struct {
+	name    string
+	keys    int
+	bytes   int
+	noTTL   int
+	expired int
+	biggest []KeyInfo // sorted from the biggest to the smallest
+	longest []KeyInfo // sorted from the longest to the shortest
+}
+
+func typeTotals(s *Stats, typ string) (count, bytes int) {
+	switch typ {
+	case stringType:
+		return s.Strings.Count, s.Strings.TotalByteSize
+	case listType:
+		return s.Lists.Count, s.Lists.TotalByteSize
+	case setType:
+		return s.Sets.Count, s.Sets.TotalByteSize
+	case hashType:
+		return s.Hashes.Count, s.Hashes.TotalByteSize
+	case sortedSetType:
+		return s.SortedSets.Count, s.SortedSets.TotalByteSize
+	case streamType:
+		return s.Streams.Count, s.Streams.TotalByteSize
+	case moduleType:
+		return s.Modules.Count, s.Modules.TotalByteSize
+	default:
+		return s.Keys.Count, s.TotalByteSize()
+	}
+}
+
+func (r Rule) scope(s *Stats) (ruleScope, error) {
+	if r.Prefix != "" {
+		if s.Prefixes == nil {
+			return ruleScope{}, errors.New("the stats have no prefix data, enable the prefixes collector")
+		}
+
+		// Split like the key names of the stats, the delimiter is unknown in
+		// the older stats files
+		delimiter := s.PrefixDelimiter
+		if delimiter == "" {
+			delimiter = flPrefixDelimiter
+		}
+
+		prefix := strings.TrimSuffix(r.Prefix, delimiter)
+		name := "keys below " + prefix
+		if r.Type != "" {
+			name = r.Type + " " + name
+		}
+
+		// Normalized like the prefixes of the tree
+		segments := strings.Split(prefix, delimiter)
+		for i, segment := range segments {
+			if isIdentifier(segment) {
+				segments[i] = wildcardPrefix
+			}
+		}
+		if len(segments) > s.Prefixes.Depth() {
+			return ruleScope{}, fmt.Errorf("the prefix %q is deeper than the prefixes of the stats, see -prefix-depth", r.Prefix)
+		}
+
+		node := s.Prefixes.Lookup(segments)
+		if node == nil {
+			return ruleScope{name: name}, nil
+		}
+
+		sc := ruleScope{
+			name:    name,
+			keys:    node.Keys,
+			bytes:   node.Bytes,
+			noTTL:   node.TTL.None,
+			expired: node.TTL.Expired,
+			biggest: node.TopKeys,
+		}
+		if r.Type != "" {
+			sc.bytes = node.TypeBytes[r.Type]
+		}
+
+		return sc, nil
+	}
+
+	switch {
+	case r.Type == "" && r.MaxKeySize != nil && s.TopKeys == nil:
+		return ruleScope{}, errors.New("the stats have no biggest keys, enable the topkeys collector")
+	case r.Type != "" && r.MaxKeySize != nil && s.BiggestKeys == nil:
+		return ruleScope{}, errors.New("the stats have no size data per type, enable the biggest collector")
+	case r.MaxLength != nil && s.LongestKeys == nil:
+		return ruleScope{}, errors.New("the stats have no length data, enable the longest collector")
+	}
+
+	sc := ruleScope{
+		name:    "all keys",
+		noTTL:   s.Keys.Count - s.Keys.Expiring - s.Keys.Expired,
+		expired: s.Keys.Expired,
+		biggest: s.TopKeys,
+	}
+	sc.keys, sc.bytes = typeTotals(s, r.Type)
+
+	if r.Type != "" {
+		sc.name = r.Type + " keys"
+		sc.biggest = s.BiggestKeys[r.Type]
+		sc.longest = s.LongestKeys[r.Type]
+	} else {
+		for _, t := range keyTypes {
+			sc.longest = append(sc.longest, s.LongestKeys[t]...)
+		}
+	}
+
+	return sc, nil
+}
+
+// ruleResult is the result of the evaluation of a rule.
+type ruleResult struct {
+	rule       Rule
+	violations []string
+	err        error
+}
+
+func (r ruleResult) failed() bool {
+	return r.err != nil || len(r.violations) > 0
+}
+
+func (r Rule) evaluate(s *Stats) ruleResult {
+	res := ruleResult{rule: r}
+
+	sc, err := r.scope(s)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	violation := func(format string, args ...interface{}) {
+		res.violations = append(res.violations, sc.name+": "+fmt.Sprintf(format, args...))
+	}
+
+	if r.MaxKeys != nil && sc.keys > *r.MaxKeys {
+		violation("%d keys > %d", sc.keys, *r.MaxKeys)
+	}
+	if r.MaxBytes != nil && sc.bytes > *r.MaxBytes {
+		violation("%s > %s", humanBytes(sc.bytes), humanBytes(*r.MaxBytes))
+	}
+	if pct := percent(sc.bytes, s.TotalByteSize()); r.MaxBytesPercent != nil && pct > *r.MaxBytesPercent {
+		violation("%.2f%% of the bytes > %.2f%%", pct, *r.MaxBytesPercent)
+	}
+	if r.MaxNoTTLKeys != nil && sc.noTTL > *r.MaxNoTTLKeys {
+		violation("%d keys without TTL > %d", sc.noTTL, *r.MaxNoTTLKeys)
+	}
+	if pct := percent(sc.noTTL, sc.keys); r.MaxNoTTLPercent != nil && pct > *r.MaxNoTTLPercent {
+		violation("%.2f%% of the keys without TTL > %.2f%%", pct, *r.MaxNoTTLPercent)
+	}
+	if pct := percent(sc.expired, sc.keys); r.MaxExpiredPercent != nil && pct > *r.MaxExpiredPercent {
+		violation("%.2f%% of the keys expired > %.2f%%", pct, *r.MaxExpiredPercent)
+	}
+	if r.MaxKeySize != nil {
+		for _, k := range sc.biggest {
+			if k.Size > *r.MaxKeySize {
+				violation("key %q in db %d is %s > %s", k.Name, k.DB, humanBytes(k.Size), humanBytes(*r.MaxKeySize))
+			}
+		}
+	}
+	if r.MaxLength != nil {
+		for _, k := range sc.longest {
+			if k.Length > *r.MaxLength {
+				violation("%s %q in db %d has %d elements > %d", k.Type, k.Name, k.DB, k.Length, *r.MaxLength)
+			}
+		}
+	}
+
+	return res
+}
+
+func evaluateRules(s *Stats, rules []Rule) []ruleResult {
+	var res []ruleResult
+	for _, r := range rules {
+		res = append(res, r.evaluate(s))
+	}
+	return res
+}
+
+func writeRuleResults(w io.Writer, results []ruleResult) {
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			fmt.Fprintf(w, "ERROR %s: %v\n", r.rule.Name, r.err)
+		case len(r.violations) > 0:
+			fmt.Fprintf(w, "FAIL  %s\n", r.rule.Name)
+			for _, v := range r.violations {
+				fmt.Fprintf(w, "        %s\n", v)
+			}
+		default:
+			fmt.Fprintf(w, "ok    %s\n", r.rule.Name)
+		}
+	}
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results of the rules evaluated on the file filename
+// as a JUnit XML report, one test case per rule.
+func writeJUnit(w io.Writer, filename string, results []ruleResult, elapsed time.Duration) error {
+	suite := junitTestSuite{
+		Name:  "rdbanalyzer check " + filename,
+		Tests: len(results),
+		Time:  fmt.Sprintf("%.3f", elapsed.Seconds()),
+	}
+
+	for _, r := range results {
+		tc := junitTestCase{
+			Name:      r.rule.Name,
+			ClassName: "rdbanalyzer.check",
+		}
+
+		switch {
+		case r.err != nil:
+			suite.Errors++
+			tc.Error = &junitMessage{Message: r.err.Error()}
+		case len(r.violations) > 0:
+			suite.Failures++
+			tc.Failure = &junitMessage{
+				Message: fmt.Sprintf("%d violations", len(r.violations)),
+				Text:    strings.Join(r.violations, "\n"),
+			}
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeJUnitFile(filename, input string, results []ruleResult, elapsed time.Duration) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("unable to create file '%s'. err=%v", filename, err)
+	}
+	defer f.Close()
+
+	if err := writeJUnit(f, input, results, elapsed); err != nil {
+		return fmt.Errorf("unable to write JUnit report. err=%v", err)
+	}
+
+	return nil
+}
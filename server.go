@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server serves the visualizations and a JSON API for stats kept in memory.
+//
+// Stats may be nil if no RDB file was given on the command line. When jobs is
+// not nil, RDB files can also be uploaded and analyzed in the background.
+//
+// The stats are never modified once given to the server: setStats replaces
+// them, which also invalidates the SVG documents rendered so far.
+type server struct {
+	jobs    *jobManager
+	watcher *watcher
+	mux     *http.ServeMux
+
+	mu      sync.Mutex
+	stats   *Stats
+	modTime time.Time
+	svgs    map[string]*renderedSVG
+}
+
+// renderedSVG is a SVG document rendered once and served until the stats change.
+type renderedSVG struct {
+	data    []byte
+	gzipped []byte
+	etag    string
+}
+
+func newServer(s *Stats, jobs *jobManager) *server {
+	srv := &server{
+		jobs: jobs,
+		mux:  http.NewServeMux(),
+	}
+	srv.setStats(s)
+
+	srv.mux.HandleFunc("/", srv.handleIndex)
+	srv.mux.HandleFunc("/svg/", srv.handleSVG)
+	srv.mux.HandleFunc("/api/stats", srv.handleStats)
+	srv.mux.HandleFunc("/api/keys/top", srv.handleTopKeys)
+	srv.mux.HandleFunc("/api/prefixes", srv.handlePrefixes)
+	srv.mux.HandleFunc("/metrics", srv.handleMetrics)
+	if jobs != nil {
+		srv.mux.HandleFunc("/analyze", srv.handleAnalyze)
+		srv.mux.HandleFunc("/jobs/", srv.handleJob)
+		srv.mux.HandleFunc("/reports/", srv.handleReport)
+	}
+
+	return srv
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+// setWatcher makes the server show the snapshots kept by w.
+func (s *server) setWatcher(w *watcher) {
+	s.watcher = w
+
+	s.mux.HandleFunc("/history", s.handleHistory)
+	s.mux.HandleFunc("/history/", s.handleSnapshot)
+}
+
+// setStats replaces the stats served.
+func (s *server) setStats(st *Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats = st
+	s.modTime = time.Now()
+	s.svgs = make(map[string]*renderedSVG)
+}
+
+// currentStats returns the stats served and when they were last changed.
+func (s *server) currentStats() (*Stats, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats, s.modTime
+}
+
+// renderSVG returns the SVG document named name, rendering it if it is not
+// cached yet. It returns nil if there is no such document.
+func (s *server) renderSVG(name string, st *Stats) (*renderedSVG, error) {
+	s.mu.Lock()
+	r, ok := s.svgs[name]
+	s.mu.Unlock()
+
+	if ok {
+		return r, nil
+	}
+
+	var buf bytes.Buffer
+	if name == "report" {
+		if err := generateSVG(&buf, st); err != nil {
+			return nil, err
+		}
+	} else {
+		c, ok := findPanel(name)
+		if !ok || !c.isAvailable(st) {
+			return nil, nil
+		}
+
+		if err := generateChartSVG(&buf, c, st); err != nil {
+			return nil, err
+		}
+	}
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(buf.Bytes())
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	r = &renderedSVG{
+		data:    buf.Bytes(),
+		gzipped: gzipped.Bytes(),
+		etag:    hex.EncodeToString(sum[:]),
+	}
+
+	// Only cache the document if the stats haven't been replaced in the meantime.
+	s.mu.Lock()
+	if s.stats == st {
+		s.svgs[name] = r
+	}
+	s.mu.Unlock()
+
+	return r, nil
+}
+
+func noStats(w http.ResponseWriter) {
+	http.Error(w, "no RDB file analyzed", http.StatusNotFound)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>RDB statistics</title></head>
+<body>
+<h1>RDB statistics</h1>
+{{if .Upload}}<p><a href="/analyze">Analyze a RDB file</a></p>
+{{end}}{{if .Watch}}<h2>Snapshots</h2>
+{{if .Snapshots}}<table>
+<tr><th>Analyzed</th><th>File size</th><th>Keys</th><th>Bytes</th><th>Parsing time</th></tr>
+{{range .Snapshots}}<tr><td><a href="{{.Report}}">{{.Analyzed.Format "2006-01-02 15:04:05"}}</a></td><td>{{.FileSize}}</td><td>{{.Keys}}</td><td>{{.TotalByteSize}}</td><td>{{.ParseDuration}}</td></tr>
+{{end}}</table>
+{{else}}<p>The RDB file has not been analyzed yet.</p>
+{{end}}<p><a href="/history">history</a>: the snapshots as JSON</p>
+{{end}}{{if .Charts}}<h2>Charts</h2>
+<ul>
+<li><a href="svg/report">full report</a></li>
+{{range .Charts}}<li><a href="svg/{{.Name}}">{{.Title}}</a></li>
+{{end}}</ul>
+<h2>API</h2>
+<ul>
+<li><a href="api/stats">api/stats</a>: all the stats</li>
+<li><a href="api/keys/top">api/keys/top</a>: the biggest keys, use <code>?n=</code> to limit the number of keys</li>
+<li><a href="api/prefixes">api/prefixes</a>: the key prefixes, use <code>?path=a:b</code> to drill down</li>
+<li><a href="metrics">metrics</a>: the stats as Prometheus metrics</li>
+</ul>
+{{end}}</body>
+</html>
+`))
+
+// handleIndex serves the index page. The links are relative so that the page
+// also works for the reports of uploaded files served under /reports/<id>/.
+func (s *server) handleIndex(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	type link struct{ Name, Title string }
+
+	var data struct {
+		Upload    bool
+		Watch     bool
+		Snapshots []snapshotSummary
+		Charts    []link
+	}
+
+	data.Upload = s.jobs != nil
+	if s.watcher != nil {
+		data.Watch = true
+		data.Snapshots = s.watcher.summaries()
+	}
+	if st, _ := s.currentStats(); st != nil {
+		for _, p := range panels {
+			if p.isAvailable(st) {
+				data.Charts = append(data.Charts, link{p.name, p.title})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.Copy(w, &buf)
+}
+
+func (s *server) handleSVG(w http.ResponseWriter, req *http.Request) {
+	st, modTime := s.currentStats()
+	if st == nil {
+		noStats(w)
+		return
+	}
+
+	r, err := s.renderSVG(strings.TrimPrefix(req.URL.Path, "/svg/"), st)
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case r == nil:
+		http.NotFound(w, req)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "image/svg+xml")
+	h.Set("Vary", "Accept-Encoding")
+
+	data := r.data
+	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		data = r.gzipped
+		h.Set("Content-Encoding", "gzip")
+		h.Set("ETag", `"`+r.etag+`-gzip"`)
+	} else {
+		h.Set("ETag", `"`+r.etag+`"`)
+	}
+
+	http.ServeContent(w, req, "", modTime, bytes.NewReader(data))
+}
+
+func (s *server) handleStats(w http.ResponseWriter, req *http.Request) {
+	st, _ := s.currentStats()
+	if st == nil {
+		noStats(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *server) handleTopKeys(w http.ResponseWriter, req *http.Request) {
+	st, _ := s.currentStats()
+	if st == nil {
+		noStats(w)
+		return
+	}
+
+	keys := st.TopKeys
+
+	if v := req.URL.Query().Get("n"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid parameter n", http.StatusBadRequest)
+			return
+		}
+
+		if n < len(keys) {
+			keys = keys[:n]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// prefixResponse is the representation of a prefix node in the API: only the
+// direct children are returned so the tree can be explored one level at a time.
+type prefixResponse struct {
+	Path      string
+	Keys      int
+	Bytes     int
+	TypeBytes map[string]int
+	TTL       TTLDistribution
+	TopKeys   []KeyInfo
+	Children  []prefixChild
+}
+
+type prefixChild struct {
+	Name        string
+	Keys        int
+	Bytes       int
+	HasChildren bool
+}
+
+func (s *server) handlePrefixes(w http.ResponseWriter, req *http.Request) {
+	st, _ := s.currentStats()
+	if st == nil {
+		noStats(w)
+		return
+	}
+
+	if st.Prefixes == nil {
+		http.Error(w, "no prefix data available", http.StatusNotFound)
+		return
+	}
+
+	var path []string
+	if v := req.URL.Query().Get("path"); v != "" {
+		path = strings.Split(v, flPrefixDelimiter)
+	}
+
+	node := st.Prefixes.Lookup(path)
+	if node == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	resp := prefixResponse{
+		Path:      strings.Join(path, flPrefixDelimiter),
+		Keys:      node.Keys,
+		Bytes:     node.Bytes,
+		TypeBytes: node.TypeBytes,
+		TTL:       node.TTL,
+		TopKeys:   node.TopKeys,
+		Children:  []prefixChild{},
+	}
+	for _, c := range node.SortedChildren() {
+		resp.Children = append(resp.Children, prefixChild{
+			Name:        c.Name,
+			Keys:        c.Keys,
+			Bytes:       c.Bytes,
+			HasChildren: len(c.Children) > 0,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// serve serves the stats, which may be nil, and the upload form on flListenAddr.
+func serve(s *Stats) error {
+	fmt.Printf("listening on %s\n", flListenAddr)
+
+	jobs := newJobManager(flMaxJobs, flMaxReports)
+	if err := http.ListenAndServe(flListenAddr, newServer(s, jobs)); err != nil {
+		return fmt.Errorf("unable to listen on %s. err=%v", flListenAddr, err)
+	}
+
+	return nil
+}
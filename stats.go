@@ -3,11 +3,24 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"time"
 )
 
 type DatabaseStats struct {
-	Count int
+	Count     int
+	Databases []DBStats
+}
+
+// DBStats are the stats of a single database.
+type DBStats struct {
+	DB       int
+	Keys     int
+	Bytes    int
+	Expiring int
+	Expired  int
 }
 
 type KeyStats struct {
@@ -49,6 +62,29 @@ type SortedSetStats struct {
 	TotalByteSize int
 }
 
+// StreamStats are the stats of the stream keys.
+type StreamStats struct {
+	Count          int
+	TotalByteSize  int
+	Entries        int
+	Groups         int // consumer groups
+	Consumers      int
+	PendingEntries int // in the PELs of the consumer groups
+}
+
+// ModuleStats are the stats of the keys of the types defined by modules.
+type ModuleStats struct {
+	Count         int
+	TotalByteSize int
+	Types         map[string]int `json:",omitempty"` // number of keys per module type
+}
+
+// FunctionStats are the stats of the libraries of functions.
+type FunctionStats struct {
+	Libraries     int
+	TotalByteSize int
+}
+
 type Stats struct {
 	// TODO(vincent): locking ?
 
@@ -59,10 +95,38 @@ type Stats struct {
 	Sets       SetStats
 	Hashes     HashStats
 	SortedSets SortedSetStats
+	Streams    StreamStats
+	Modules    ModuleStats
+	Functions  FunctionStats
+
+	// The results of the collectors, nil if they are disabled
+	TopKeys         []KeyInfo
+	LongestKeys     map[string][]KeyInfo // per type
+	BiggestKeys     map[string][]KeyInfo // per type
+	SizeHistogram   []SizeBucket         `json:",omitempty"`
+	Prefixes        *PrefixNode
+	PrefixDelimiter string            `json:",omitempty"` // the key names were split with
+	NoTTL           *NoTTLStats       `json:",omitempty"`
+	Encodings       *EncodingStats    `json:",omitempty"`
+	Advisor         *AdvisorStats     `json:",omitempty"`
+	StringProfile   *StringProfile    `json:",omitempty"`
+	Compression     *CompressionStats `json:",omitempty"`
+	Duplicates      *DuplicateStats   `json:",omitempty"`
+	Distinct        *DistinctStats    `json:",omitempty"`
+	HashFields      *HashFieldStats   `json:",omitempty"`
+
+	RDB *RDBInfo `json:",omitempty"` // the header of the file, nil if it is unknown
+
+	ParseDuration time.Duration
+}
+
+func (s Stats) TotalByteSize() int {
+	return s.Strings.TotalByteSize + s.Lists.TotalByteSize + s.Sets.TotalByteSize + s.Hashes.TotalByteSize + s.SortedSets.TotalByteSize +
+		s.Streams.TotalByteSize + s.Modules.TotalByteSize
 }
 
 func (s Stats) SpaceUsage() SpaceUsageProportions {
-	total := float64(s.Strings.TotalByteSize + s.Lists.TotalByteSize + s.Sets.TotalByteSize + s.Hashes.TotalByteSize + s.SortedSets.TotalByteSize)
+	total := float64(s.TotalByteSize())
 
 	return SpaceUsageProportions{
 		Strings:    float64(s.Strings.TotalByteSize) / total * 100,
@@ -70,6 +134,8 @@ func (s Stats) SpaceUsage() SpaceUsageProportions {
 		Sets:       float64(s.Sets.TotalByteSize) / total * 100,
 		Hashes:     float64(s.Hashes.TotalByteSize) / total * 100,
 		SortedSets: float64(s.SortedSets.TotalByteSize) / total * 100,
+		Streams:    float64(s.Streams.TotalByteSize) / total * 100,
+		Modules:    float64(s.Modules.TotalByteSize) / total * 100,
 	}
 }
 
@@ -79,15 +145,18 @@ type SpaceUsageProportions struct {
 	Sets       float64
 	Hashes     float64
 	SortedSets float64
+	Streams    float64
+	Modules    float64
 }
 
-func writeStats(filename string) error {
+func writeStats(filename string, s *Stats) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("unable to create file '%s'. err=%v", filename, err)
 	}
+	defer f.Close()
 
-	data, err := json.MarshalIndent(&stats, "", "  ")
+	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return fmt.Errorf("unable to marshal stats. err=%v", err)
 	}
@@ -99,3 +168,41 @@ func writeStats(filename string) error {
 
 	return nil
 }
+
+func readStats(filename string) (*Stats, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read stats file '%s'. err=%v", filename, err)
+	}
+
+	var s Stats
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal stats. err=%v", err)
+	}
+
+	return &s, nil
+}
+
+// loadStats returns the stats of filename, which is either a stats file
+// written by writeStats or a RDB file to analyze.
+func loadStats(filename string) (*Stats, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file '%s'. err=%v", filename, err)
+	}
+
+	var magic [1]byte
+	_, err = io.ReadFull(f, magic[:])
+	f.Close()
+
+	if err == nil && magic[0] == '{' {
+		return readStats(filename)
+	}
+
+	var s Stats
+	if err := parse(filename, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The classes of the string values, guessed from their first bytes and, for
+// the formats without a magic number, a quick validation of their structure.
+const (
+	emptyClass    = "empty"
+	integerClass  = "integer"
+	uuidClass     = "uuid"
+	jsonClass     = "json"
+	textClass     = "text"
+	gzipClass     = "gzip"
+	zstdClass     = "zstd"
+	lz4Class      = "lz4"
+	snappyClass   = "snappy"
+	msgpackClass  = "msgpack"
+	protobufClass = "protobuf"
+	phpClass      = "php-serialized"
+	javaClass     = "java-serialized"
+	pickleClass   = "python-pickle"
+	binaryClass   = "binary"
+)
+
+const (
+	// maxJSONValidate is the maximum size of the JSON values validated, the
+	// bigger ones are only checked at both ends.
+	maxJSONValidate = 64 * 1024
+
+	// maxProfiledPrefixes is the number of prefixes tracked, see boundedKey.
+	maxProfiledPrefixes = 10000
+
+	// maxProfiledPrefixesReported is the number of prefixes stored in the stats.
+	maxProfiledPrefixesReported = 100
+)
+
+var (
+	gzipMagic         = []byte{0x1F, 0x8B}
+	zstdMagic         = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	lz4Magic          = []byte{0x04, 0x22, 0x4D, 0x18}
+	snappyFramedMagic = []byte("\xFF\x06\x00\x00sNaPpY")
+	javaMagic         = []byte{0xAC, 0xED, 0x00, 0x05}
+)
+
+// classifyValue returns the class of the string value v.
+func classifyValue(v []byte) string {
+	switch {
+	case len(v) == 0:
+		return emptyClass
+	case stringEncoding(v) == intEncoding:
+		return integerClass
+	case len(v) == 36 && isIdentifier(string(v)) && strings.Count(string(v), "-") == 4:
+		return uuidClass
+	case bytes.HasPrefix(v, gzipMagic):
+		return gzipClass
+	case bytes.HasPrefix(v, zstdMagic):
+		return zstdClass
+	case bytes.HasPrefix(v, lz4Magic):
+		return lz4Class
+	case bytes.HasPrefix(v, snappyFramedMagic):
+		return snappyClass
+	case bytes.HasPrefix(v, javaMagic):
+		return javaClass
+	case isPickle(v):
+		return pickleClass
+	case isPHPSerialized(v):
+		return phpClass
+	case isJSON(v):
+		return jsonClass
+	}
+
+	if utf8.Valid(v) {
+		if isText(v) {
+			return textClass
+		}
+	}
+
+	switch {
+	case isMsgpack(v):
+		return msgpackClass
+	case isRawSnappy(v):
+		return snappyClass
+	case isProtobuf(v):
+		return protobufClass
+	default:
+		return binaryClass
+	}
+}
+
+// isPickle reports whether v starts with the PROTO opcode of the pickle
+// protocols 2 and above and ends with the STOP opcode.
+func isPickle(v []byte) bool {
+	return len(v) >= 3 && v[0] == 0x80 && v[1] >= 2 && v[1] <= 5 && v[len(v)-1] == '.'
+}
+
+// isPHPSerialized reports whether v looks like the output of serialize().
+func isPHPSerialized(v []byte) bool {
+	if len(v) < 2 {
+		return false
+	}
+	switch {
+	case bytes.Equal(v, []byte("N;")):
+		return true
+	case v[1] != ':':
+		return false
+	}
+	switch v[0] {
+	case 'a', 'O', 'C':
+		return v[len(v)-1] == '}'
+	case 's':
+		return bytes.HasSuffix(v, []byte(`";`))
+	case 'i', 'd', 'b':
+		return v[len(v)-1] == ';'
+	default:
+		return false
+	}
+}
+
+// isJSON reports whether v is a JSON object or array.
+func isJSON(v []byte) bool {
+	t := bytes.TrimSpace(v)
+	if len(t) < 2 {
+		return false
+	}
+	if !(t[0] == '{' && t[len(t)-1] == '}') && !(t[0] == '[' && t[len(t)-1] == ']') {
+		return false
+	}
+	if len(t) > maxJSONValidate {
+		return true
+	}
+	return json.Valid(t)
+}
+
+// isText reports whether v is mostly made of printable characters.
+func isText(v []byte) bool {
+	var control int
+	for _, r := range string(v) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			control++
+		}
+	}
+	return control*20 <= len(v)
+}
+
+// isMsgpack reports whether v starts with a msgpack map or array: a map must
+// start with a string key.
+func isMsgpack(v []byte) bool {
+	if len(v) < 2 {
+		return false
+	}
+
+	b := v[0]
+	switch {
+	case b >= 0x81 && b <= 0x8F: // fixmap
+		return isMsgpackString(v[1])
+	case b == 0xDE: // map 16
+		return len(v) > 3 && isMsgpackString(v[3])
+	case b == 0xDF: // map 32
+		return len(v) > 5 && isMsgpackString(v[5])
+	case b >= 0x91 && b <= 0x9F, b == 0xDC, b == 0xDD: // arrays
+		return true
+	default:
+		return false
+	}
+}
+
+func isMsgpackString(b byte) bool {
+	return (b >= 0xA0 && b <= 0xBF) || b == 0xD9 || b == 0xDA || b == 0xDB
+}
+
+// isRawSnappy reports whether v looks like a snappy block: the uncompressed
+// length, bigger than v, followed by a literal.
+func isRawSnappy(v []byte) bool {
+	n, i := binary.Uvarint(v)
+	if i <= 0 || i >= len(v) {
+		return false
+	}
+	return n > uint64(len(v)) && n < uint64(len(v))*32 && v[i]&0x03 == 0
+}
+
+// isProtobuf reports whether v is a valid sequence of protobuf fields.
+func isProtobuf(v []byte) bool {
+	for i := 0; i < len(v); {
+		tag, n := binary.Uvarint(v[i:])
+		if n <= 0 || tag>>3 == 0 {
+			return false
+		}
+		i += n
+
+		switch tag & 0x07 {
+		case 0: // varint
+			if _, n = binary.Uvarint(v[i:]); n <= 0 {
+				return false
+			}
+			i += n
+		case 1: // 64 bits
+			i += 8
+		case 2: // length delimited
+			l, n := binary.Uvarint(v[i:])
+			if n <= 0 || l > uint64(len(v)) {
+				return false
+			}
+			i += n + int(l)
+		case 5: // 32 bits
+			i += 4
+		default:
+			return false
+		}
+
+		if i > len(v) {
+			return false
+		}
+	}
+	return len(v) > 0
+}
+
+// ValueClass counts the strings of a class.
+type ValueClass struct {
+	Class string
+	Keys  int
+	Bytes int
+}
+
+// PrefixProfile counts the strings of a top level prefix per class.
+type PrefixProfile struct {
+	Prefix  string
+	Keys    int
+	Bytes   int
+	Classes []ValueClass // sorted by bytes, biggest first
+}
+
+// StringProfile are the classes of the string values, in total and per top
+// level prefix.
+type StringProfile struct {
+	Classes  []ValueClass // sorted by bytes, biggest first
+	Prefixes []PrefixProfile
+}
+
+type classCounts map[string]*ValueClass
+
+func (c classCounts) add(class string, size int) {
+	vc, ok := c[class]
+	if !ok {
+		vc = &ValueClass{Class: class}
+		c[class] = vc
+	}
+	vc.Keys++
+	vc.Bytes += size
+}
+
+func (c classCounts) sorted() []ValueClass {
+	var res []ValueClass
+	for _, vc := range c {
+		res = append(res, *vc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bytes != res[j].Bytes {
+			return res[i].Bytes > res[j].Bytes
+		}
+		return res[i].Class < res[j].Class
+	})
+	return res
+}
+
+// stringProfiler classifies the string values.
+type stringProfiler struct {
+	delimiter string
+	classes   classCounts
+	prefixes  map[string]classCounts
+}
+
+func newStringProfiler(delimiter string) *stringProfiler {
+	return &stringProfiler{
+		delimiter: delimiter,
+		classes:   make(classCounts),
+		prefixes:  make(map[string]classCounts),
+	}
+}
+
+func (p *stringProfiler) addValue(k KeyInfo, v []byte) {
+	if k.Type != stringType {
+		return
+	}
+
+	class := classifyValue(v)
+	p.classes.add(class, len(v))
+
+	prefix := boundedKey(p.prefixes, topLevelPrefix(k.Name, p.delimiter), maxProfiledPrefixes)
+	counts, ok := p.prefixes[prefix]
+	if !ok {
+		counts = make(classCounts)
+		p.prefixes[prefix] = counts
+	}
+	counts.add(class, len(v))
+}
+
+func (p *stringProfiler) add(k KeyInfo) {}
+
+func (p *stringProfiler) finish(s *Stats) {
+	res := StringProfile{Classes: p.classes.sorted()}
+
+	for name, counts := range p.prefixes {
+		pp := PrefixProfile{Prefix: name, Classes: counts.sorted()}
+		for _, vc := range pp.Classes {
+			pp.Keys += vc.Keys
+			pp.Bytes += vc.Bytes
+		}
+		res.Prefixes = append(res.Prefixes, pp)
+	}
+	sort.Slice(res.Prefixes, func(i, j int) bool {
+		a, b := res.Prefixes[i], res.Prefixes[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Prefix < b.Prefix
+	})
+	if len(res.Prefixes) > maxProfiledPrefixesReported {
+		res.Prefixes = res.Prefixes[:maxProfiledPrefixesReported]
+	}
+
+	s.StringProfile = &res
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestClassifyValue(t *testing.T) {
+	tests := []struct {
+		value string
+		class string
+	}{
+		{"", emptyClass},
+		{"12345", integerClass},
+		{"-42", integerClass},
+		{"0123", textClass},
+		{"123e4567-e89b-12d3-a456-426614174000", uuidClass},
+		{"\x1f\x8b\x08\x00\x00\x00\x00\x00", gzipClass},
+		{"\x28\xb5\x2f\xfd\x00\x58", zstdClass},
+		{"\x04\x22\x4d\x18\x64\x40", lz4Class},
+		{"\xff\x06\x00\x00sNaPpY\x01", snappyClass},
+		{"\xac\xed\x00\x05t\x00\x03abc", javaClass},
+		{"\x80\x04K\x01.", pickleClass},
+		{`a:1:{i:0;s:1:"a";}`, phpClass},
+		{`s:3:"abc";`, phpClass},
+		{"N;", phpClass},
+		{`{"name": "a", "tags": [1, 2]}`, jsonClass},
+		{" [1, 2, 3] ", jsonClass},
+		{"{not json}", textClass},
+		{"hello world\n", textClass},
+		{"\x81\xa1a\x01", msgpackClass},
+		{"\x64\xf0\xff\xfe\x00\x80", snappyClass},
+		{"\x08\x96\x01", protobufClass},
+		{"\xff\xfe\x00", binaryClass},
+		{"a\x01b\x02c", binaryClass},
+	}
+
+	for _, tt := range tests {
+		if got := classifyValue([]byte(tt.value)); got != tt.class {
+			t.Errorf("%q: got %s, expected %s", tt.value, got, tt.class)
+		}
+	}
+}
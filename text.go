@@ -0,0 +1,488 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	textBarWidth = 30
+
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiBlue  = "\x1b[34m"
+)
+
+// humanBytes formats n bytes with a binary unit.
+func humanBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// percent returns the percentage of n in total.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// isTerminal returns true if w is a terminal, which interprets the escape
+// sequences of the colors.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// errWriter keeps the first error of the writes to w, the writes after it
+// are ignored.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+// textReport writes the stats as aligned tables.
+type textReport struct {
+	w     *errWriter
+	bars  bool
+	color bool
+	top   int
+}
+
+// newTextReport returns a report written to w, colored if w is a terminal.
+func newTextReport(w io.Writer) *textReport {
+	return &textReport{
+		w:     &errWriter{w: w},
+		bars:  flTextBars,
+		color: !flNoColor && isTerminal(w),
+		top:   flTextTop,
+	}
+}
+
+func (r *textReport) title(s string) {
+	if r.color {
+		s = ansiBold + s + ansiReset
+	}
+	fmt.Fprintf(r.w, "\n%s\n", s)
+}
+
+// bar returns a bar whose length is proportional to pct, or an empty string
+// if bars are disabled. pct is clamped to [0, 100], it's out of it in the
+// stats files edited by hand.
+func (r *textReport) bar(pct float64) string {
+	if !r.bars {
+		return ""
+	}
+
+	n := int(pct/100*textBarWidth + 0.5)
+	switch {
+	case n < 0:
+		n = 0
+	case n > textBarWidth:
+		n = textBarWidth
+	}
+	s := strings.Repeat("█", n) + strings.Repeat("░", textBarWidth-n)
+	if r.color {
+		s = ansiBlue + s + ansiReset
+	}
+
+	return s
+}
+
+// table returns a tabwriter; bars are always in the last column so that the
+// escape sequences don't break the alignment.
+func (r *textReport) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(r.w, 0, 0, 2, ' ', 0)
+}
+
+// compressionTable writes the ratio and the bytes saved per codec of the
+// first n groups, all of them if n is negative.
+func (r *textReport) compressionTable(name string, groups []CompressionGroup, n int) {
+	tw := r.table()
+
+	header := name + "\tVALUES\tSIZE\tSAMPLED"
+	if len(groups) > 0 {
+		for _, e := range groups[0].Codecs {
+			header += "\t" + strings.ToUpper(e.Codec) + "\tSAVED"
+		}
+	}
+	fmt.Fprintln(tw, header)
+
+	for i, g := range groups {
+		if n >= 0 && i >= n {
+			break
+		}
+		line := fmt.Sprintf("%s\t%d\t%s\t%d", g.Name, g.Values, humanBytes(g.Bytes), g.SampledValues)
+		for _, e := range g.Codecs {
+			if g.SampledValues == 0 {
+				line += "\t-\t-"
+				continue
+			}
+			line += fmt.Sprintf("\t%.2f\t%s", e.Ratio, signedBytes(e.SavedBytes))
+		}
+		fmt.Fprintln(tw, line)
+	}
+
+	tw.Flush()
+}
+
+// distinctTable writes the distinct elements of the first n groups, all of
+// them if n is negative.
+func (r *textReport) distinctTable(groups []DistinctGroup, n int) {
+	tw := r.table()
+	fmt.Fprintln(tw, "PREFIX\tTYPE\tKEYS\tELEMENTS\tDISTINCT\tSHARED BY")
+	for i, g := range groups {
+		if n >= 0 && i >= n {
+			break
+		}
+		prefix := g.Prefix
+		if prefix == "" {
+			prefix = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d %s\t%d\t%.1f keys\n", prefix, g.Type, g.Keys, g.Elements, g.Element, g.Distinct, g.Shared())
+	}
+	tw.Flush()
+}
+
+func (r *textReport) write(s *Stats) error {
+	total := s.TotalByteSize()
+
+	r.title("Global")
+	tw := r.table()
+	fmt.Fprintf(tw, "Databases\t%d\n", s.Database.Count)
+	fmt.Fprintf(tw, "Keys\t%d\n", s.Keys.Count)
+	fmt.Fprintf(tw, "Total size\t%s\n", humanBytes(total))
+	fmt.Fprintf(tw, "Parsing time\t%s\n", s.ParseDuration.Round(time.Millisecond))
+	tw.Flush()
+
+	if info := s.RDB; info != nil {
+		r.title("RDB file")
+		tw = r.table()
+		fmt.Fprintf(tw, "Version\t%d\n", info.Version)
+		for _, name := range info.AuxNames() {
+			fmt.Fprintf(tw, "%s\t%s\n", name, info.Aux[name])
+		}
+		if used := info.UsedMemory(); used > 0 {
+			fmt.Fprintf(tw, "Estimated size / used-mem\t%s / %s (%.2f%%)\n", humanBytes(total), humanBytes(used), percent(total, used))
+		}
+		for _, h := range info.Resize {
+			fmt.Fprintf(tw, "RESIZEDB db %d\t%d keys, %d with expiry\n", h.DB, h.Keys, h.Expiring)
+		}
+		tw.Flush()
+	}
+
+	r.title("Types")
+	tw = r.table()
+	fmt.Fprintln(tw, "TYPE\tKEYS\tSIZE\t%\t")
+	types := []struct {
+		name  string
+		count int
+		bytes int
+	}{
+		{"strings", s.Strings.Count, s.Strings.TotalByteSize},
+		{"lists", s.Lists.Count, s.Lists.TotalByteSize},
+		{"sets", s.Sets.Count, s.Sets.TotalByteSize},
+		{"hashes", s.Hashes.Count, s.Hashes.TotalByteSize},
+		{"zsets", s.SortedSets.Count, s.SortedSets.TotalByteSize},
+		{"streams", s.Streams.Count, s.Streams.TotalByteSize},
+		{"modules", s.Modules.Count, s.Modules.TotalByteSize},
+	}
+	for _, t := range types {
+		pct := percent(t.bytes, total)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.2f\t%s\n", t.name, t.count, humanBytes(t.bytes), pct, r.bar(pct))
+	}
+	tw.Flush()
+
+	if s.Streams.Count > 0 {
+		r.title("Streams")
+		tw = r.table()
+		fmt.Fprintln(tw, "ENTRIES\tGROUPS\tCONSUMERS\tPENDING")
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\n", s.Streams.Entries, s.Streams.Groups, s.Streams.Consumers, s.Streams.PendingEntries)
+		tw.Flush()
+	}
+
+	if s.Modules.Count > 0 {
+		r.title("Modules")
+		tw = r.table()
+		fmt.Fprintln(tw, "TYPE\tKEYS")
+		var names []string
+		for name := range s.Modules.Types {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(tw, "%s\t%d\n", name, s.Modules.Types[name])
+		}
+		tw.Flush()
+	}
+
+	if s.Functions.Libraries > 0 {
+		r.title("Functions")
+		tw = r.table()
+		fmt.Fprintf(tw, "Libraries\t%d\n", s.Functions.Libraries)
+		fmt.Fprintf(tw, "Code size\t%s\n", humanBytes(s.Functions.TotalByteSize))
+		tw.Flush()
+	}
+
+	r.title("Expiry")
+	tw = r.table()
+	fmt.Fprintln(tw, "STATUS\tKEYS\t%\t")
+	normal := s.Keys.Count - s.Keys.Expiring - s.Keys.Expired
+	for _, e := range []struct {
+		name  string
+		count int
+	}{
+		{"expired", s.Keys.Expired},
+		{"expiring", s.Keys.Expiring},
+		{"normal", normal},
+	} {
+		pct := percent(e.count, s.Keys.Count)
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%s\n", e.name, e.count, pct, r.bar(pct))
+	}
+	tw.Flush()
+
+	r.title("Databases")
+	tw = r.table()
+	fmt.Fprintln(tw, "DB\tKEYS\tSIZE\tEXPIRING\tEXPIRED\t%\t")
+	for _, db := range s.Database.Databases {
+		pct := percent(db.Bytes, total)
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%d\t%.2f\t%s\n", db.DB, db.Keys, humanBytes(db.Bytes), db.Expiring, db.Expired, pct, r.bar(pct))
+	}
+	tw.Flush()
+
+	r.title(fmt.Sprintf("Top %d keys", r.top))
+	tw = r.table()
+	fmt.Fprintln(tw, "DB\tKEY\tTYPE\tLENGTH\tSIZE\t%\t")
+	for i, k := range s.TopKeys {
+		if i >= r.top {
+			break
+		}
+		pct := percent(k.Size, total)
+		fmt.Fprintf(tw, "%d\t%q\t%s\t%d\t%s\t%.2f\t%s\n", k.DB, k.Name, k.Type, k.Length, humanBytes(k.Size), pct, r.bar(pct))
+	}
+	tw.Flush()
+
+	if s.Prefixes != nil {
+		r.title(fmt.Sprintf("Top %d prefixes", r.top))
+		tw = r.table()
+		fmt.Fprintln(tw, "PREFIX\tKEYS\tSIZE\t%\t")
+		for i, c := range s.Prefixes.SortedChildren() {
+			if i >= r.top {
+				break
+			}
+			pct := percent(c.Bytes, total)
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%.2f\t%s\n", c.Name, c.Keys, humanBytes(c.Bytes), pct, r.bar(pct))
+		}
+		tw.Flush()
+	}
+
+	if s.NoTTL != nil {
+		r.title(fmt.Sprintf("Top %d namespaces without TTL", r.top))
+		tw = r.table()
+		fmt.Fprintln(tw, "NAMESPACE\tKEYS\tNO TTL\tSIZE NO TTL\tLEAKING\t%\t")
+		for i, ns := range s.NoTTL.Namespaces {
+			if i >= r.top || ns.NoTTLKeys == 0 {
+				break
+			}
+
+			leaking := ""
+			if ns.Leaking() {
+				leaking = "yes"
+			}
+			pct := percent(ns.NoTTLKeys, ns.Keys)
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%.2f\t%s\n", ns.Name, ns.Keys, ns.NoTTLKeys, humanBytes(ns.NoTTLBytes), leaking, pct, r.bar(pct))
+		}
+		tw.Flush()
+	}
+
+	if e := s.Encodings; e != nil {
+		r.title("Encodings")
+		tw = r.table()
+		fmt.Fprintln(tw, "TYPE\tENCODING\tKEYS\tSIZE\t%\t")
+		for _, te := range e.Encodings {
+			pct := percent(te.Bytes, total)
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%.2f\t%s\n", te.Type, te.Encoding, te.Keys, humanBytes(te.Bytes), pct, r.bar(pct))
+		}
+		tw.Flush()
+
+		r.title(fmt.Sprintf("Collections up to %.0f%% above the encoding thresholds", e.Margin*100))
+		tw = r.table()
+		fmt.Fprintln(tw, "SETTING\tLIMIT\tKEYS\tSIZE\tBIGGEST")
+		for _, n := range e.NearThresholds {
+			var biggest string
+			if len(n.Biggest) > 0 {
+				k := n.Biggest[0]
+				biggest = fmt.Sprintf("%q (%d elements, %s)", k.Name, k.Length, humanBytes(k.Size))
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", n.Setting, n.Limit, n.Keys, humanBytes(n.Bytes), biggest)
+		}
+		tw.Flush()
+		if n := e.UnknownKeys(); n > 0 {
+			fmt.Fprintf(r.w, "The encoding of %d keys is unknown, rdbtools doesn't report it for the files up to RDB version 6: they are not compared to the thresholds.\n", n)
+		}
+	}
+
+	if s.Advisor != nil {
+		r.title("Encoding thresholds advisor")
+		tw = r.table()
+		fmt.Fprintln(tw, "SETTING\tVALUE\tKEYS\tCOMPACT\t%\tSAVED\t")
+		for _, a := range s.Advisor.Settings {
+			if a.Keys == 0 {
+				continue
+			}
+			best := a.Best()
+			for _, c := range a.Candidates {
+				mark := ""
+				switch {
+				case c.Value == a.Current:
+					mark = "current"
+				case c.Value == best.Value:
+					mark = "best"
+				}
+				pct := percent(c.CompactKeys, a.Keys)
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f\t%s\t%s\n", a.Setting, c.Value, a.Keys, c.CompactKeys, pct, signedBytes(c.SavedBytes), mark)
+			}
+		}
+		tw.Flush()
+	}
+
+	if p := s.StringProfile; p != nil {
+		r.title("String values")
+		tw = r.table()
+		fmt.Fprintln(tw, "CLASS\tKEYS\tSIZE\t%\t")
+		for _, vc := range p.Classes {
+			pct := percent(vc.Bytes, s.Strings.TotalByteSize)
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%.2f\t%s\n", vc.Class, vc.Keys, humanBytes(vc.Bytes), pct, r.bar(pct))
+		}
+		tw.Flush()
+
+		r.title(fmt.Sprintf("String values of the top %d prefixes", r.top))
+		tw = r.table()
+		fmt.Fprintln(tw, "PREFIX\tKEYS\tSIZE\tCLASSES")
+		for i, pp := range p.Prefixes {
+			if i >= r.top {
+				break
+			}
+			var classes []string
+			for _, vc := range pp.Classes {
+				classes = append(classes, fmt.Sprintf("%s %.0f%%", vc.Class, percent(vc.Bytes, pp.Bytes)))
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", pp.Prefix, pp.Keys, humanBytes(pp.Bytes), strings.Join(classes, ", "))
+		}
+		tw.Flush()
+	}
+
+	if c := s.Compression; c != nil {
+		title := fmt.Sprintf("Estimated compression, %.2f%% of the values sampled", 100*c.Sample)
+		if c.BudgetExhausted {
+			title += fmt.Sprintf(", budget of %s exhausted", c.Budget)
+		}
+		r.title(title)
+		r.compressionTable("TYPE", append(c.Types, c.Total()), -1)
+
+		r.title(fmt.Sprintf("Estimated compression of the top %d prefixes", r.top))
+		r.compressionTable("PREFIX", c.Prefixes, r.top)
+	}
+
+	if d := s.Duplicates; d != nil {
+		about := ""
+		if !d.Exact {
+			about = ", estimated"
+		}
+		r.title("Duplicated string values" + about)
+		tw = r.table()
+		fmt.Fprintf(tw, "Values\t%d\n", d.DuplicatedValues)
+		fmt.Fprintf(tw, "Keys\t%d (%.2f%%)\n", d.DuplicateKeys, percent(d.DuplicateKeys, d.Values))
+		fmt.Fprintf(tw, "Wasted\t%s (%.2f%%)\n", humanBytes(d.WastedBytes), percent(d.WastedBytes, d.Bytes))
+		tw.Flush()
+
+		r.title(fmt.Sprintf("Top %d duplicated values", r.top))
+		tw = r.table()
+		fmt.Fprintln(tw, "HASH\tSIZE\tCOPIES\tWASTED\tCLASS\tKEYS\tPREVIEW")
+		for i, v := range d.Top {
+			if i >= r.top {
+				break
+			}
+			keys := make([]string, len(v.Keys))
+			for j, k := range v.Keys {
+				keys[j] = strconv.Quote(k)
+			}
+			preview := ""
+			if v.Preview != "" {
+				preview = strconv.Quote(v.Preview)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", v.Hash, humanBytes(v.Size), v.Count, humanBytes(v.WastedBytes), v.Class, strings.Join(keys, ", "), preview)
+		}
+		tw.Flush()
+	}
+
+	if d := s.Distinct; d != nil {
+		r.title("Distinct elements, estimated")
+		r.distinctTable(d.Types, -1)
+
+		r.title(fmt.Sprintf("Distinct elements of the top %d prefixes, estimated", r.top))
+		r.distinctTable(d.Prefixes, r.top)
+	}
+
+	if h := s.HashFields; h != nil {
+		for i, p := range h.Patterns {
+			if i >= r.top {
+				break
+			}
+			title := fmt.Sprintf("Hash fields of %s: %d hashes, %s", p.Pattern, p.Keys, humanBytes(p.Bytes))
+			if p.Truncated {
+				title += fmt.Sprintf(", more than %d distinct fields", maxHashFields)
+			}
+			r.title(title)
+			tw = r.table()
+			fmt.Fprintln(tw, "FIELD\tHASHES\tNAMES\tVALUES\tMAX VALUE\t%\t")
+			for j, f := range p.Fields {
+				if j >= r.top {
+					break
+				}
+				name := strconv.Quote(f.Name)
+				if f.Estimated {
+					name += " (estimated)"
+				}
+				pct := percent(f.Bytes(), p.Bytes)
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%.2f\t%s\n", name, f.Keys, humanBytes(f.NameBytes), humanBytes(f.ValueBytes), humanBytes(f.MaxValue), pct, r.bar(pct))
+			}
+			tw.Flush()
+		}
+	}
+
+	return r.w.err
+}
+
+func writeTextReport(w io.Writer, s *Stats) error {
+	return newTextReport(w).write(s)
+}
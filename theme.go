@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const defaultTheme = "dark"
+
+// theme holds the colors of the SVG report, as hexadecimal RGB values.
+//
+// The categorical colors are color-blind safe: the dark and light themes use
+// the Okabe-Ito palette, the print theme the vibrant palette of Paul Tol which
+// also holds up in grayscale.
+type theme struct {
+	page       string // background of the report
+	panel      string // background of the panels
+	border     string // around the panels, none if empty
+	text       string
+	axis       string
+	grid       string
+	legend     string // background of the legends
+	legendText string
+
+	types   map[string]string // per key type, the same in all the charts
+	series  []string          // for the values which are not key types
+	alert   string            // expired keys, keys without TTL
+	caution string            // expiring keys
+	neutral string            // everything else, like the keys neither expired nor expiring
+	other   string            // the "other" slice grouping the small ones
+}
+
+var themes = map[string]theme{
+	"dark": {
+		page:       "000000",
+		panel:      "1A1A1A",
+		text:       "E6E6E6",
+		axis:       "BFBFBF",
+		grid:       "404040",
+		legend:     "262626",
+		legendText: "E6E6E6",
+		types:      okabeItoTypes,
+		series:     []string{"56B4E9", "E69F00"},
+		alert:      "D55E00",
+		caution:    "F0E442",
+		neutral:    "999999",
+		other:      "5C5C5C",
+	},
+	"light": {
+		page:       "FFFFFF",
+		panel:      "F2F2F2",
+		text:       "1A1A1A",
+		axis:       "4D4D4D",
+		grid:       "D9D9D9",
+		legend:     "FFFFFF",
+		legendText: "1A1A1A",
+		types:      okabeItoTypes,
+		series:     []string{"0072B2", "E69F00"},
+		alert:      "D55E00",
+		caution:    "F0E442",
+		neutral:    "999999",
+		other:      "666666",
+	},
+	"print": {
+		page:       "FFFFFF",
+		panel:      "FFFFFF",
+		border:     "000000",
+		text:       "000000",
+		axis:       "000000",
+		grid:       "BBBBBB",
+		legend:     "FFFFFF",
+		legendText: "000000",
+		types: map[string]string{
+			stringType:    "0077BB",
+			listType:      "EE7733",
+			setType:       "009988",
+			hashType:      "EE3377",
+			sortedSetType: "33BBEE",
+			streamType:    "DDAA33",
+			moduleType:    "882255",
+		},
+		series:  []string{"0077BB", "EE7733"},
+		alert:   "CC3311",
+		caution: "DDAA33",
+		neutral: "BBBBBB",
+		other:   "777777",
+	},
+}
+
+// okabeItoTypes are the colors of the types in the dark and light themes. The
+// palette has no eighth color which differs from both the text and the
+// backgrounds, so the modules take the wine of the muted palette of Paul Tol,
+// like in the print theme.
+var okabeItoTypes = map[string]string{
+	stringType:    "0072B2",
+	listType:      "E69F00",
+	setType:       "009E73",
+	hashType:      "CC79A7",
+	sortedSetType: "56B4E9",
+	streamType:    "F0E442",
+	moduleType:    "882255",
+}
+
+func themeNames() []string {
+	var res []string
+	for name := range themes {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func checkTheme(name string) error {
+	if _, ok := themes[name]; !ok {
+		return fmt.Errorf("unknown theme %q", name)
+	}
+	return nil
+}
+
+// reportTheme returns the theme selected with -theme.
+func reportTheme() theme {
+	t, ok := themes[flTheme]
+	if !ok {
+		t = themes[defaultTheme]
+	}
+	return t
+}
+
+// panelStyle returns the style of the background of a panel.
+func (t theme) panelStyle() string {
+	if t.border == "" {
+		return "fill:#" + t.panel
+	}
+	return fmt.Sprintf("fill:#%s;stroke:#%s;stroke-width:1", t.panel, t.border)
+}
+
+func (t theme) axisStyle() string { return fmt.Sprintf("stroke:#%s;stroke-width:1", t.axis) }
+
+func (t theme) gridStyle() string { return fmt.Sprintf("stroke:#%s;stroke-width:1", t.grid) }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// maxSearchResults bounds the number of nodes returned by a search.
+const maxSearchResults = 200
+
+// explorer is a full screen terminal UI to explore the prefix tree.
+//
+// The left pane lists the children of the current node sorted by size, the
+// right pane shows the type mix, the TTL distribution and the biggest keys of
+// the selected child.
+type explorer struct {
+	stats *Stats
+
+	path     [][]*PrefixNode // the current node is the last element of the last path
+	selected int
+	offset   int
+
+	searching bool
+	query     string
+	results   [][]*PrefixNode // nil when the search results are not shown
+}
+
+func newExplorer(s *Stats) *explorer {
+	return &explorer{
+		stats: s,
+		path:  [][]*PrefixNode{{s.Prefixes}},
+	}
+}
+
+func (e *explorer) current() []*PrefixNode {
+	return e.path[len(e.path)-1]
+}
+
+// items returns the paths of the nodes listed in the left pane.
+func (e *explorer) items() [][]*PrefixNode {
+	if e.results != nil {
+		return e.results
+	}
+
+	cur := e.current()
+
+	var res [][]*PrefixNode
+	for _, c := range cur[len(cur)-1].SortedChildren() {
+		p := make([]*PrefixNode, len(cur)+1)
+		copy(p, cur)
+		p[len(cur)] = c
+		res = append(res, p)
+	}
+
+	return res
+}
+
+// selectedNode returns the node shown in the right pane.
+func (e *explorer) selectedNode() []*PrefixNode {
+	items := e.items()
+	if e.selected < len(items) {
+		return items[e.selected]
+	}
+	return e.current()
+}
+
+func pathName(path []*PrefixNode) string {
+	var names []string
+	for _, n := range path[1:] {
+		names = append(names, n.Name)
+	}
+
+	if len(names) == 0 {
+		return "(all keys)"
+	}
+	return strings.Join(names, flPrefixDelimiter)
+}
+
+// search returns the nodes whose path contains the query, biggest first.
+func (e *explorer) search(query string) [][]*PrefixNode {
+	query = strings.ToLower(query)
+
+	var (
+		res  [][]*PrefixNode
+		walk func(path []*PrefixNode)
+	)
+	walk = func(path []*PrefixNode) {
+		node := path[len(path)-1]
+		for _, c := range node.Children {
+			p := make([]*PrefixNode, len(path)+1)
+			copy(p, path)
+			p[len(path)] = c
+
+			if strings.Contains(strings.ToLower(pathName(p)), query) {
+				res = append(res, p)
+			}
+			walk(p)
+		}
+	}
+	walk([]*PrefixNode{e.stats.Prefixes})
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i][len(res[i])-1].Bytes > res[j][len(res[j])-1].Bytes
+	})
+	if len(res) > maxSearchResults {
+		res = res[:maxSearchResults]
+	}
+
+	return res
+}
+
+func (e *explorer) move(delta int) {
+	n := len(e.items())
+
+	e.selected += delta
+	if e.selected >= n {
+		e.selected = n - 1
+	}
+	if e.selected < 0 {
+		e.selected = 0
+	}
+}
+
+func (e *explorer) enter() {
+	items := e.items()
+	if e.selected >= len(items) {
+		return
+	}
+
+	p := items[e.selected]
+	if e.results == nil && len(p[len(p)-1].Children) == 0 {
+		return
+	}
+
+	e.path = append(e.path, p)
+	e.results = nil
+	e.selected, e.offset = 0, 0
+}
+
+func (e *explorer) back() {
+	switch {
+	case e.results != nil:
+		e.results = nil
+	case len(e.path) > 1:
+		e.path = e.path[:len(e.path)-1]
+	default:
+		return
+	}
+	e.selected, e.offset = 0, 0
+}
+
+// handle handles a key event. It returns false when the explorer must quit.
+func (e *explorer) handle(ev termbox.Event) bool {
+	if e.searching {
+		switch {
+		case ev.Key == termbox.KeyEsc:
+			e.searching = false
+		case ev.Key == termbox.KeyEnter:
+			e.searching = false
+			e.results = e.search(e.query)
+			e.selected, e.offset = 0, 0
+		case ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2:
+			if e.query != "" {
+				_, size := utf8.DecodeLastRuneInString(e.query)
+				e.query = e.query[:len(e.query)-size]
+			}
+		case ev.Key == termbox.KeySpace:
+			e.query += " "
+		case ev.Ch != 0:
+			e.query += string(ev.Ch)
+		}
+		return true
+	}
+
+	_, h := termbox.Size()
+	page := h - 3
+
+	switch {
+	case ev.Key == termbox.KeyCtrlC, ev.Key == termbox.KeyEsc, ev.Ch == 'q':
+		return false
+	case ev.Key == termbox.KeyArrowUp, ev.Ch == 'k':
+		e.move(-1)
+	case ev.Key == termbox.KeyArrowDown, ev.Ch == 'j':
+		e.move(1)
+	case ev.Key == termbox.KeyPgup:
+		e.move(-page)
+	case ev.Key == termbox.KeyPgdn:
+		e.move(page)
+	case ev.Key == termbox.KeyHome:
+		e.move(-len(e.items()))
+	case ev.Key == termbox.KeyEnd:
+		e.move(len(e.items()))
+	case ev.Key == termbox.KeyEnter, ev.Key == termbox.KeyArrowRight, ev.Ch == 'l':
+		e.enter()
+	case ev.Key == termbox.KeyArrowLeft, ev.Key == termbox.KeyBackspace, ev.Key == termbox.KeyBackspace2, ev.Ch == 'h':
+		e.back()
+	case ev.Ch == '/':
+		e.searching = true
+		e.query = ""
+	}
+
+	return true
+}
+
+// tuiPrint prints s at x, y, truncated to width cells.
+func tuiPrint(x, y, width int, fg, bg termbox.Attribute, s string) {
+	i := 0
+	for _, r := range s {
+		if i >= width {
+			break
+		}
+		termbox.SetCell(x+i, y, r, fg, bg)
+		i++
+	}
+}
+
+func (e *explorer) draw() {
+	const (
+		fg = termbox.ColorDefault
+		bg = termbox.ColorDefault
+	)
+
+	termbox.Clear(fg, bg)
+
+	w, h := termbox.Size()
+	leftWidth := w / 2
+	rightX := leftWidth + 1
+	rightWidth := w - rightX
+
+	// Header
+
+	header := " " + pathName(e.current())
+	if e.results != nil {
+		header = fmt.Sprintf(" search results for %q", e.query)
+	}
+	for x := 0; x < w; x++ {
+		termbox.SetCell(x, 0, ' ', fg|termbox.AttrReverse, bg)
+	}
+	tuiPrint(0, 0, w, fg|termbox.AttrReverse, bg, header)
+
+	// Left pane: the list of nodes
+
+	items := e.items()
+	listHeight := h - 2
+
+	if e.selected < e.offset {
+		e.offset = e.selected
+	}
+	if e.selected >= e.offset+listHeight {
+		e.offset = e.selected - listHeight + 1
+	}
+
+	parent := e.current()[len(e.current())-1]
+	for i := 0; i < listHeight && e.offset+i < len(items); i++ {
+		p := items[e.offset+i]
+		node := p[len(p)-1]
+
+		name := node.Name
+		if e.results != nil {
+			name = pathName(p)
+		}
+		if len(node.Children) > 0 {
+			name += flPrefixDelimiter
+		}
+
+		var line string
+		if e.results != nil {
+			line = fmt.Sprintf("%10s %8d  %s", humanBytes(node.Bytes), node.Keys, name)
+		} else {
+			line = fmt.Sprintf("%10s %6.2f%% %8d  %s", humanBytes(node.Bytes), percent(node.Bytes, parent.Bytes), node.Keys, name)
+		}
+
+		lfg, lbg := fg, bg
+		if e.offset+i == e.selected {
+			lfg, lbg = termbox.ColorBlack, termbox.ColorCyan
+			for x := 0; x < leftWidth; x++ {
+				termbox.SetCell(x, i+1, ' ', lfg, lbg)
+			}
+		}
+		tuiPrint(0, i+1, leftWidth, lfg, lbg, line)
+	}
+	if len(items) == 0 {
+		tuiPrint(0, 1, leftWidth, fg, bg, "no prefix below this node")
+	}
+
+	for y := 1; y < h-1; y++ {
+		termbox.SetCell(leftWidth, y, '│', fg, bg)
+	}
+
+	// Right pane: the details of the selected node
+
+	sel := e.selectedNode()
+	node := sel[len(sel)-1]
+
+	y := 1
+	tuiPrint(rightX, y, rightWidth, fg|termbox.AttrBold, bg, pathName(sel))
+	y += 2
+
+	tuiPrint(rightX, y, rightWidth, fg|termbox.AttrBold, bg, "Type mix")
+	y++
+	for _, t := range keyTypes {
+		n := node.TypeBytes[t]
+		tuiPrint(rightX, y, rightWidth, fg, bg, fmt.Sprintf("%-8s %10s %6.2f%%", t, humanBytes(n), percent(n, node.Bytes)))
+		y++
+	}
+	y++
+
+	tuiPrint(rightX, y, rightWidth, fg|termbox.AttrBold, bg, "TTL distribution")
+	y++
+	for _, b := range node.TTL.buckets() {
+		tuiPrint(rightX, y, rightWidth, fg, bg, fmt.Sprintf("%-8s %10d %6.2f%%", b.name, b.count, percent(b.count, node.Keys)))
+		y++
+	}
+	y++
+
+	tuiPrint(rightX, y, rightWidth, fg|termbox.AttrBold, bg, "Biggest keys")
+	y++
+	for _, k := range node.TopKeys {
+		if y >= h-1 {
+			break
+		}
+		tuiPrint(rightX, y, rightWidth, fg, bg, fmt.Sprintf("%10s %-6s %s", humanBytes(k.Size), k.Type, k.Name))
+		y++
+	}
+
+	// Status line
+
+	status := " ↑↓ move  → enter  ← back  / search  q quit"
+	if e.searching {
+		status = " search: " + e.query + "_"
+	}
+	tuiPrint(0, h-1, w, fg, bg, status)
+
+	termbox.Flush()
+}
+
+// explore runs the explorer until the user quits.
+func explore(s *Stats) error {
+	if s.Prefixes == nil {
+		return fmt.Errorf("no prefix data available")
+	}
+
+	if err := termbox.Init(); err != nil {
+		return fmt.Errorf("unable to initialize the terminal. err=%v", err)
+	}
+	defer termbox.Close()
+
+	e := newExplorer(s)
+	for {
+		e.draw()
+
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventKey:
+			if !e.handle(ev) {
+				return nil
+			}
+		case termbox.EventError:
+			return ev.Err
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshot is the analysis of one version of the watched RDB file.
+type snapshot struct {
+	id       int
+	analyzed time.Time
+	size     int64
+	modTime  time.Time
+	stats    *Stats
+	handler  http.Handler
+}
+
+// snapshotSummary is the representation of a snapshot in the API.
+type snapshotSummary struct {
+	ID            int
+	Analyzed      time.Time
+	FileSize      int64
+	FileModTime   time.Time
+	ParseDuration string
+	Keys          int
+	TotalByteSize int
+	Report        string
+}
+
+func (s *snapshot) summary() snapshotSummary {
+	return snapshotSummary{
+		ID:            s.id,
+		Analyzed:      s.analyzed,
+		FileSize:      s.size,
+		FileModTime:   s.modTime,
+		ParseDuration: s.stats.ParseDuration.String(),
+		Keys:          s.stats.Keys.Count,
+		TotalByteSize: s.stats.TotalByteSize(),
+		Report:        "/history/" + strconv.Itoa(s.id) + "/",
+	}
+}
+
+// watcher polls a RDB file and analyzes it again each time it is replaced or
+// modified, which Redis does on every BGSAVE. The stats of the last analysis are
+// served by srv and the last few snapshots are kept in memory.
+type watcher struct {
+	path     string
+	interval time.Duration
+	history  int
+	srv      *server
+
+	mu        sync.Mutex
+	lastID    int
+	snapshots []*snapshot // oldest first
+}
+
+func newWatcher(path string, interval time.Duration, history int, srv *server) *watcher {
+	return &watcher{
+		path:     path,
+		interval: interval,
+		history:  history,
+		srv:      srv,
+	}
+}
+
+// changed returns true if the file described by fi is different from the one
+// described by prev: it has been replaced by another file or modified in place.
+func changed(prev, fi os.FileInfo) bool {
+	switch {
+	case prev == nil:
+		return true
+	case !os.SameFile(prev, fi):
+		return true
+	default:
+		return prev.Size() != fi.Size() || !prev.ModTime().Equal(fi.ModTime())
+	}
+}
+
+func (w *watcher) run() {
+	var prev os.FileInfo
+
+	for {
+		fi, err := os.Stat(w.path)
+		switch {
+		case err != nil:
+			log.Printf("unable to stat RDB file '%s'. err=%v", w.path, err)
+		case changed(prev, fi):
+			// On an error, like a file still being written, the file is
+			// analyzed again on the next poll
+			if err := w.analyze(fi); err != nil {
+				log.Print(err)
+				break
+			}
+			prev = fi
+		}
+
+		time.Sleep(w.interval)
+	}
+}
+
+// analyze analyzes the RDB file described by fi and keeps its stats as the
+// last snapshot.
+func (w *watcher) analyze(fi os.FileInfo) error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return fmt.Errorf("unable to open file '%s'. err=%v", w.path, err)
+	}
+	defer f.Close()
+
+	log.Printf("parsing RDB file %s", w.path)
+
+	var s Stats
+	if err := analyzeRDB(f, &s); err != nil {
+		return err
+	}
+
+	log.Printf("parsing time: %s", s.ParseDuration)
+
+	if flPromTextfile != "" {
+		if err := writePromTextfile(flPromTextfile, &s); err != nil {
+			log.Printf("unable to write metrics. err=%v", err)
+		}
+	}
+
+	snap := &snapshot{
+		analyzed: time.Now(),
+		size:     fi.Size(),
+		modTime:  fi.ModTime(),
+		stats:    &s,
+	}
+
+	w.mu.Lock()
+	w.lastID++
+	snap.id = w.lastID
+	snap.handler = http.StripPrefix("/history/"+strconv.Itoa(snap.id), newServer(&s, nil))
+
+	w.snapshots = append(w.snapshots, snap)
+	if len(w.snapshots) > w.history {
+		w.snapshots = w.snapshots[len(w.snapshots)-w.history:]
+	}
+	w.mu.Unlock()
+
+	w.srv.setStats(&s)
+
+	return nil
+}
+
+// summaries returns the summaries of the snapshots kept, newest first.
+func (w *watcher) summaries() []snapshotSummary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	res := make([]snapshotSummary, 0, len(w.snapshots))
+	for i := len(w.snapshots) - 1; i >= 0; i-- {
+		res = append(res, w.snapshots[i].summary())
+	}
+
+	return res
+}
+
+func (w *watcher) get(id int) *snapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, s := range w.snapshots {
+		if s.id == id {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (s *server) handleHistory(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.watcher.summaries())
+}
+
+func (s *server) handleSnapshot(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/history/")
+
+	id := path
+	if i := strings.Index(path, "/"); i >= 0 {
+		id = path[:i]
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	snap := s.watcher.get(n)
+	switch {
+	case snap == nil:
+		http.NotFound(w, req)
+	case path == id:
+		http.Redirect(w, req, "/history/"+id+"/", http.StatusMovedPermanently)
+	default:
+		snap.handler.ServeHTTP(w, req)
+	}
+}
+
+// watchAndServe starts the web server and analyzes the RDB file at path each
+// time it changes.
+func watchAndServe(path string) error {
+	srv := newServer(nil, newJobManager(flMaxJobs, flMaxReports))
+
+	w := newWatcher(path, flWatchInterval, flHistory, srv)
+	srv.setWatcher(w)
+
+	go w.run()
+
+	log.Printf("watching %s, listening on %s", path, flListenAddr)
+
+	return http.ListenAndServe(flListenAddr, srv)
+}